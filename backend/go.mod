@@ -7,6 +7,7 @@ require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/golang-jwt/jwt/v5 v5.0.0
 	github.com/joho/godotenv v1.4.0
+	go.etcd.io/bbolt v1.3.8
 	golang.org/x/oauth2 v0.15.0
 )
 
@@ -0,0 +1,175 @@
+// Package api defines the representations the HTTP API serves, kept apart
+// from the GitHub responses they are built from so that a change on
+// GitHub's side doesn't change what clients receive.
+package api
+
+import (
+	_ "embed"
+
+	"github-repo-manager/internal/repository"
+)
+
+// Version is the version of the representations in this package. Fields may
+// be added within a version; removing or changing one needs a new version.
+const Version = "1"
+
+// RepositorySchema is the JSON schema of Repository.
+//
+//go:embed schema/repository.v1.json
+var RepositorySchema []byte
+
+// Repository is a repository as served by the API. Every field is always
+// present; fields GitHub may not report are null rather than omitted,
+// except the ones only the GraphQL listing backend fills in.
+type Repository struct {
+	ID              int          `json:"id"`
+	Name            string       `json:"name"`
+	FullName        string       `json:"full_name"`
+	Owner           Owner        `json:"owner"`
+	Description     string       `json:"description"`
+	Homepage        string       `json:"homepage"`
+	Visibility      string       `json:"visibility"`
+	Private         bool         `json:"private"`
+	Archived        bool         `json:"archived"`
+	Disabled        bool         `json:"disabled"`
+	Fork            bool         `json:"fork"`
+	IsTemplate      bool         `json:"is_template"`
+	HTMLURL         string       `json:"html_url"`
+	CloneURL        string       `json:"clone_url"`
+	DefaultBranch   string       `json:"default_branch"`
+	Language        *string      `json:"language"`
+	Topics          []string     `json:"topics"`
+	License         *License     `json:"license"`
+	Permissions     *Permissions `json:"permissions"`
+	HasIssues       bool         `json:"has_issues"`
+	HasProjects     bool         `json:"has_projects"`
+	HasWiki         bool         `json:"has_wiki"`
+	Size            int          `json:"size"`
+	StargazersCount int          `json:"stargazers_count"`
+	WatchersCount   int          `json:"watchers_count"`
+	ForksCount      int          `json:"forks_count"`
+	OpenIssuesCount int          `json:"open_issues_count"`
+	CreatedAt       string       `json:"created_at"`
+	UpdatedAt       string       `json:"updated_at"`
+	PushedAt        *string      `json:"pushed_at"`
+
+	// Only listed by the GraphQL backend.
+	Languages              map[string]int `json:"languages,omitempty"`
+	DefaultBranchProtected *bool          `json:"default_branch_protected,omitempty"`
+	LastCommitAt           string         `json:"last_commit_at,omitempty"`
+}
+
+type Owner struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
+	HTMLURL   string `json:"html_url"`
+}
+
+type License struct {
+	Key    string  `json:"key"`
+	Name   string  `json:"name"`
+	SPDXID *string `json:"spdx_id"`
+}
+
+// Permissions are the signed-in user's on the repository.
+type Permissions struct {
+	Admin    bool `json:"admin"`
+	Maintain bool `json:"maintain"`
+	Push     bool `json:"push"`
+	Triage   bool `json:"triage"`
+	Pull     bool `json:"pull"`
+}
+
+// NewRepository builds the API representation of repo, or nil for nil.
+func NewRepository(repo *repository.Repository) *Repository {
+	if repo == nil {
+		return nil
+	}
+
+	topics := repo.Topics
+	if topics == nil {
+		topics = []string{}
+	}
+	// Repositories cached before visibility was recorded only know
+	// whether they are private.
+	visibility := repo.Visibility
+	if visibility == "" {
+		visibility = "public"
+		if repo.Private {
+			visibility = "private"
+		}
+	}
+
+	view := &Repository{
+		ID:       repo.ID,
+		Name:     repo.Name,
+		FullName: repo.FullName,
+		Owner: Owner{
+			Login:     repo.Owner.Login,
+			AvatarURL: repo.Owner.AvatarURL,
+			HTMLURL:   repo.Owner.HTMLURL,
+		},
+		Description:            repo.Description,
+		Homepage:               repo.Homepage,
+		Visibility:             visibility,
+		Private:                repo.Private,
+		Archived:               repo.Archived,
+		Disabled:               repo.Disabled,
+		Fork:                   repo.Fork,
+		IsTemplate:             repo.IsTemplate,
+		HTMLURL:                repo.HTMLURL,
+		CloneURL:               repo.CloneURL,
+		DefaultBranch:          repo.DefaultBranch,
+		Language:               optional(repo.Language),
+		Topics:                 topics,
+		HasIssues:              repo.HasIssues,
+		HasProjects:            repo.HasProjects,
+		HasWiki:                repo.HasWiki,
+		Size:                   repo.Size,
+		StargazersCount:        repo.StargazersCount,
+		WatchersCount:          repo.WatchersCount,
+		ForksCount:             repo.ForksCount,
+		OpenIssuesCount:        repo.OpenIssuesCount,
+		CreatedAt:              repo.CreatedAt,
+		UpdatedAt:              repo.UpdatedAt,
+		PushedAt:               optional(repo.PushedAt),
+		Languages:              repo.Languages,
+		DefaultBranchProtected: repo.DefaultBranchProtected,
+		LastCommitAt:           repo.LastCommitAt,
+	}
+	if license := repo.License; license != nil {
+		// GitHub reports unrecognized licenses with the SPDX ID
+		// "NOASSERTION".
+		spdxID := optional(license.SPDXID)
+		if license.SPDXID == "NOASSERTION" {
+			spdxID = nil
+		}
+		view.License = &License{Key: license.Key, Name: license.Name, SPDXID: spdxID}
+	}
+	if permissions := repo.Permissions; permissions != nil {
+		view.Permissions = &Permissions{
+			Admin:    permissions.Admin,
+			Maintain: permissions.Maintain,
+			Push:     permissions.Push,
+			Triage:   permissions.Triage,
+			Pull:     permissions.Pull,
+		}
+	}
+	return view
+}
+
+// NewRepositories builds the API representation of a listing.
+func NewRepositories(repos []repository.Repository) []*Repository {
+	views := make([]*Repository, len(repos))
+	for i := range repos {
+		views[i] = NewRepository(&repos[i])
+	}
+	return views
+}
+
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/repository"
+)
+
+// restRepository is a repository as the REST API returns it, without a
+// language, license or push.
+const restRepository = `{
+  "id": 1, "name": "demo", "full_name": "octo/demo",
+  "owner": {"login": "octo", "avatar_url": "https://avatars.example.com/octo", "html_url": "https://github.com/octo"},
+  "description": null, "homepage": null, "visibility": "public",
+  "private": false, "archived": false, "disabled": false, "fork": false, "is_template": false,
+  "html_url": "https://github.com/octo/demo", "clone_url": "https://github.com/octo/demo.git",
+  "default_branch": "main", "language": null, "topics": [], "license": null,
+  "permissions": {"admin": true, "maintain": true, "push": true, "triage": true, "pull": true},
+  "has_issues": true, "has_projects": true, "has_wiki": false,
+  "size": 0, "stargazers_count": 0, "watchers_count": 0, "forks_count": 0, "open_issues_count": 0,
+  "created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-02T00:00:00Z", "pushed_at": null
+}`
+
+// graphqlRepository is a repository as the GraphQL backend builds it:
+// without permissions a REST listing would have, with the fields only it
+// lists and without a default branch, as for a repository without commits.
+func graphqlRepository() *repository.Repository {
+	protected := true
+	return &repository.Repository{
+		ID:         2,
+		Name:       "tool",
+		FullName:   "octo/tool",
+		Owner:      repository.Owner{Login: "octo", AvatarURL: "https://avatars.example.com/octo", HTMLURL: "https://github.com/octo"},
+		Visibility: "internal",
+		Private:    true,
+		HTMLURL:    "https://github.com/octo/tool",
+		CloneURL:   "https://github.com/octo/tool.git",
+		Language:   "Go",
+		License:    &repository.License{Key: "other", Name: "Other", SPDXID: "NOASSERTION"},
+		CreatedAt:  "2024-01-01T00:00:00Z",
+		UpdatedAt:  "2024-01-02T00:00:00Z",
+		PushedAt:   "2024-01-02T00:00:00Z",
+
+		Languages:              map[string]int{"Go": 100},
+		DefaultBranchProtected: &protected,
+		LastCommitAt:           "2024-01-02T00:00:00Z",
+	}
+}
+
+func TestNewRepositoryMatchesSchema(t *testing.T) {
+	var rest repository.Repository
+	if err := json.Unmarshal([]byte(restRepository), &rest); err != nil {
+		t.Fatal(err)
+	}
+	// Repositories cached before visibility was recorded.
+	legacy := rest
+	legacy.Visibility = ""
+	legacy.Private = true
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(RepositorySchema, &schema); err != nil {
+		t.Fatalf("failed to decode schema: %v", err)
+	}
+
+	for name, repo := range map[string]*repository.Repository{
+		"rest":    &rest,
+		"graphql": graphqlRepository(),
+		"legacy":  &legacy,
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := json.Marshal(NewRepository(repo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value interface{}
+			if err := json.Unmarshal(data, &value); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range validate(schema, value, "") {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestValidateReportsViolations(t *testing.T) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(RepositorySchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	fields := encode(t, NewRepository(graphqlRepository()))
+	delete(fields, "pushed_at")
+	fields["visibility"] = "secret"
+	fields["size"] = "big"
+	fields["extra"] = true
+
+	if problems := validate(schema, fields, ""); len(problems) != 4 {
+		t.Errorf("got %d problems, want 4: %v", len(problems), problems)
+	}
+}
+
+func TestNewRepositoryNulls(t *testing.T) {
+	var rest repository.Repository
+	if err := json.Unmarshal([]byte(restRepository), &rest); err != nil {
+		t.Fatal(err)
+	}
+	fields := encode(t, NewRepository(&rest))
+	for _, name := range []string{"language", "license", "pushed_at"} {
+		if value, ok := fields[name]; !ok || value != nil {
+			t.Errorf("%s = %v, want null", name, value)
+		}
+	}
+	if topics, ok := fields["topics"].([]interface{}); !ok || len(topics) != 0 {
+		t.Errorf("topics = %v, want an empty list", fields["topics"])
+	}
+	for _, name := range []string{"languages", "default_branch_protected", "last_commit_at"} {
+		if _, ok := fields[name]; ok {
+			t.Errorf("REST repository lists %s", name)
+		}
+	}
+
+	fields = encode(t, NewRepository(graphqlRepository()))
+	license, _ := fields["license"].(map[string]interface{})
+	if spdxID, ok := license["spdx_id"]; !ok || spdxID != nil {
+		t.Errorf("spdx_id of an unrecognized license = %v, want null", license["spdx_id"])
+	}
+	if fields["permissions"] != nil {
+		t.Errorf("permissions = %v, want null", fields["permissions"])
+	}
+	if fields["language"] != "Go" || fields["pushed_at"] != "2024-01-02T00:00:00Z" {
+		t.Errorf("unexpected language or pushed_at in %v", fields)
+	}
+
+	if NewRepository(nil) != nil {
+		t.Error("NewRepository(nil) != nil")
+	}
+}
+
+func encode(t *testing.T, view *Repository) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+// validate checks value against the subset of JSON schema that
+// RepositorySchema uses, returning a description of every violation.
+func validate(schema map[string]interface{}, value interface{}, path string) []string {
+	if path == "" {
+		path = "$"
+	}
+	var problems []string
+
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		return []string{fmt.Sprintf("%s: %v isn't of type %v", path, value, types)}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v isn't one of %v", path, value, enum))
+		}
+	}
+	if format, ok := schema["format"].(string); ok {
+		if s, ok := value.(string); ok && !matchesFormat(format, s) {
+			problems = append(problems, fmt.Sprintf("%s: %q isn't a %s", path, s, format))
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := value[name.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: required %s is missing", path, name))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				property = additional
+			}
+			if property == nil {
+				problems = append(problems, fmt.Sprintf("%s: %s isn't in the schema", path, name))
+				continue
+			}
+			problems = append(problems, validate(property, value[name], path+"."+name)...)
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				problems = append(problems, validate(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return problems
+}
+
+func matchesType(types interface{}, value interface{}) bool {
+	names, ok := types.([]interface{})
+	if !ok {
+		names = []interface{}{types}
+	}
+	for _, name := range names {
+		switch v := value.(type) {
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case float64:
+			if name == "number" || name == "integer" && v == float64(int64(v)) {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesFormat(format, value string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "uri":
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != ""
+	}
+	return true
+}
@@ -0,0 +1,246 @@
+package audit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github-repo-manager/internal/policy"
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionUpdate  = "repository.update"
+	ActionDelete  = "repository.delete"
+	ActionTrash   = "repository.trash"
+	ActionRestore = "repository.restore"
+	ActionPurge   = "repository.purge"
+)
+
+// Outcomes of an audited action.
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeSkipped   = "skipped"
+)
+
+// SystemUsername is the actor name of actions the backend takes on its own.
+const SystemUsername = "system"
+
+var auditBucket = []byte("audit_log")
+
+// Actor is who caused an action and from where. Actions taken by the
+// backend itself, such as purging the trash, have no source IP or request ID.
+type Actor struct {
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	SourceIP  string `json:"source_ip,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// State is the part of a repository's settings an action can change.
+type State map[string]interface{}
+
+// RepositoryState captures the audited settings of repo.
+func RepositoryState(repo *repository.Repository) State {
+	if repo == nil {
+		return nil
+	}
+	topics := repo.Topics
+	if topics == nil {
+		topics = []string{}
+	}
+	return State{
+		"full_name": repo.FullName,
+		"private":   repo.Private,
+		"archived":  repo.Archived,
+		"topics":    topics,
+	}
+}
+
+// Entry is one record of the audit log.
+type Entry struct {
+	Actor
+	Sequence     uint64    `json:"sequence"`
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	JobID        string    `json:"job_id,omitempty"`
+	RepositoryID int       `json:"repository_id,omitempty"`
+	FullName     string    `json:"full_name"`
+	Before       State     `json:"before,omitempty"`
+	After        State     `json:"after,omitempty"`
+	GitHubStatus int       `json:"github_status,omitempty"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+	PrevHash     string    `json:"prev_hash"`
+	Hash         string    `json:"hash"`
+}
+
+// Log is the append-only audit log. Entries are keyed by a sequence number
+// and never changed or removed. Each entry includes the hash of the one
+// before it, so editing or removing one breaks the chain.
+type Log struct {
+	db   *bolt.DB
+	keys *Keys
+	mu   sync.Mutex
+}
+
+// New opens the audit log. keys may be nil, in which case no checkpoints
+// are signed.
+func New(db *bolt.DB, keys *Keys) (*Log, error) {
+	if err := storage.EnsureBuckets(db, auditBucket, checkpointBucket, metaBucket); err != nil {
+		return nil, fmt.Errorf("failed to initialize audit log: %w", err)
+	}
+	// Logs from before the chain start was recorded get it recorded now.
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := chainStart(tx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize audit log: %w", err)
+	}
+	return &Log{db: db, keys: keys}, nil
+}
+
+// Append records an entry, filling in its sequence number, its place in the
+// hash chain and, if unset, its time.
+func (l *Log) Append(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	return l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+		prevHash, err := lastHash(bucket)
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.Sequence = seq
+		entry.PrevHash = prevHash
+		data, err := encodeEntry(entry)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(sequenceKey(seq), data); err != nil {
+			return err
+		}
+		_, err = chainStart(tx)
+		return err
+	})
+}
+
+// Record completes entry with the outcome of err and appends it. Failures
+// are logged rather than returned: the action has already happened. It does
+// nothing on a nil Log.
+func (l *Log) Record(entry *Entry, err error) {
+	if l == nil {
+		return
+	}
+
+	var protected *policy.ProtectedError
+	var apiErr *repository.APIError
+	switch {
+	case errors.As(err, &protected):
+		entry.Outcome = OutcomeSkipped
+		entry.Error = protected.Reason
+	case err != nil:
+		entry.Outcome = OutcomeFailed
+		entry.Error = err.Error()
+		if errors.As(err, &apiErr) {
+			entry.GitHubStatus = apiErr.StatusCode
+		}
+	default:
+		entry.Outcome = OutcomeSucceeded
+	}
+
+	if err := l.Append(entry); err != nil {
+		log.Printf("Failed to write audit entry for %s: %v", entry.FullName, err)
+	}
+}
+
+// Filter selects audit entries. Zero fields match everything.
+type Filter struct {
+	UserID     int
+	Action     string
+	Repository string
+	Outcome    string
+	RequestID  string
+	JobID      string
+	Since      time.Time
+	Until      time.Time
+}
+
+func (f Filter) match(entry *Entry) bool {
+	switch {
+	case f.UserID != 0 && entry.UserID != f.UserID:
+		return false
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case f.Repository != "" && !strings.EqualFold(entry.FullName, f.Repository) && fmt.Sprint(entry.RepositoryID) != f.Repository:
+		return false
+	case f.Outcome != "" && entry.Outcome != f.Outcome:
+		return false
+	case f.RequestID != "" && entry.RequestID != f.RequestID:
+		return false
+	case f.JobID != "" && entry.JobID != f.JobID:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Query returns the entries matching filter, newest first, skipping offset
+// matches and returning at most limit (all if limit <= 0), along with the
+// total number of matches.
+func (l *Log) Query(filter Filter, offset, limit int) ([]*Entry, int, error) {
+	entries := make([]*Entry, 0)
+	total := 0
+	err := l.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(auditBucket).Cursor()
+		for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+			entry, _, err := decodeEntry(data)
+			if err != nil {
+				return fmt.Errorf("failed to decode audit entry %d: %w", sequenceOf(key), err)
+			}
+			if !filter.match(entry) {
+				continue
+			}
+			total++
+			if total > offset && (limit <= 0 || len(entries) < limit) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func sequenceOf(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key)
+}
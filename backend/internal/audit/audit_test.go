@@ -0,0 +1,203 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var queryStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// appendQueryEntries appends one entry per hour from queryStart.
+func appendQueryEntries(t *testing.T, auditLog *Log) {
+	t.Helper()
+	entries := []*Entry{
+		{Actor: Actor{UserID: 1, Username: "octo", RequestID: "req-1"}, Action: ActionUpdate, RepositoryID: 10, FullName: "octo/demo", Outcome: OutcomeSucceeded},
+		{Actor: Actor{UserID: 1, Username: "octo", RequestID: "req-2"}, Action: ActionDelete, JobID: "job-1", RepositoryID: 11, FullName: "octo/old", Outcome: OutcomeFailed, Error: "boom"},
+		{Actor: Actor{UserID: 2, Username: "hubot", RequestID: "req-3"}, Action: ActionDelete, JobID: "job-1", RepositoryID: 12, FullName: "acme/site", Outcome: OutcomeSkipped},
+		{Actor: Actor{UserID: 0, Username: SystemUsername}, Action: ActionPurge, RepositoryID: 10, FullName: "Octo/Demo", Outcome: OutcomeSucceeded},
+	}
+	for i, entry := range entries {
+		entry.Time = queryStart.Add(time.Duration(i) * time.Hour)
+		if err := auditLog.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func sequences(entries []*Entry) []uint64 {
+	seqs := make([]uint64, len(entries))
+	for i, entry := range entries {
+		seqs[i] = entry.Sequence
+	}
+	return seqs
+}
+
+func TestQueryFilters(t *testing.T) {
+	auditLog := newTestLog(t, nil)
+	appendQueryEntries(t, auditLog)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64
+	}{
+		{name: "everything, newest first", filter: Filter{}, want: []uint64{4, 3, 2, 1}},
+		{name: "user", filter: Filter{UserID: 1}, want: []uint64{2, 1}},
+		{name: "action", filter: Filter{Action: ActionDelete}, want: []uint64{3, 2}},
+		{name: "repository name ignores case", filter: Filter{Repository: "octo/demo"}, want: []uint64{4, 1}},
+		{name: "repository ID", filter: Filter{Repository: "11"}, want: []uint64{2}},
+		{name: "outcome", filter: Filter{Outcome: OutcomeSkipped}, want: []uint64{3}},
+		{name: "request", filter: Filter{RequestID: "req-3"}, want: []uint64{3}},
+		{name: "job", filter: Filter{JobID: "job-1"}, want: []uint64{3, 2}},
+		{name: "since is inclusive", filter: Filter{Since: queryStart.Add(2 * time.Hour)}, want: []uint64{4, 3}},
+		{name: "until is exclusive", filter: Filter{Until: queryStart.Add(2 * time.Hour)}, want: []uint64{2, 1}},
+		{name: "combined", filter: Filter{UserID: 1, Action: ActionDelete, Outcome: OutcomeFailed}, want: []uint64{2}},
+		{name: "no match", filter: Filter{UserID: 1, JobID: "job-2"}, want: []uint64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, total, err := auditLog.Query(tt.filter, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sequences(entries); !reflect.DeepEqual(got, tt.want) || total != len(tt.want) {
+				t.Errorf("Query = %v (total %d), want %v", got, total, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	auditLog := newTestLog(t, nil)
+	appendQueryEntries(t, auditLog)
+
+	tests := []struct {
+		offset, limit int
+		want          []uint64
+	}{
+		{offset: 0, limit: 2, want: []uint64{4, 3}},
+		{offset: 2, limit: 2, want: []uint64{2, 1}},
+		{offset: 3, limit: 2, want: []uint64{1}},
+		{offset: 4, limit: 2, want: []uint64{}},
+		{offset: 1, limit: 0, want: []uint64{3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		entries, total, err := auditLog.Query(Filter{}, tt.offset, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The total counts every match, not just the page.
+		if got := sequences(entries); !reflect.DeepEqual(got, tt.want) || total != 4 {
+			t.Errorf("Query(offset %d, limit %d) = %v (total %d), want %v", tt.offset, tt.limit, got, total, tt.want)
+		}
+	}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	auditLog := newTestLog(t, nil)
+	appendQueryEntries(t, auditLog)
+	entries, _, err := auditLog.Query(Filter{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJSONLines(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	var lines int
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		if !reflect.DeepEqual(&entry, entries[lines]) {
+			t.Errorf("line %d = %+v, want %+v", lines+1, entry, entries[lines])
+		}
+		lines++
+	}
+	if lines != len(entries) {
+		t.Errorf("wrote %d lines, want %d", lines, len(entries))
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	entry := &Entry{
+		Actor:        Actor{UserID: 1, Username: "@octo", SourceIP: "203.0.113.7", RequestID: "+req"},
+		Sequence:     7,
+		Time:         queryStart,
+		Action:       ActionUpdate,
+		JobID:        "job-1",
+		RepositoryID: 10,
+		FullName:     "=cmd|' /C calc'!A0",
+		Before:       State{"private": false},
+		After:        State{"private": true},
+		GitHubStatus: 422,
+		Outcome:      OutcomeFailed,
+		Error:        "-1+1",
+		PrevHash:     "aa",
+		Hash:         "bb",
+	}
+	quiet := &Entry{Sequence: 8, Time: queryStart, Action: ActionPurge, FullName: "octo/demo", Outcome: OutcomeSucceeded}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, []*Entry{entry, quiet}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], csvHeader) {
+		t.Fatalf("rows = %q", rows)
+	}
+
+	want := []string{
+		"7", "2024-01-01T00:00:00Z", "1", "'@octo", "203.0.113.7", "'+req",
+		ActionUpdate, "job-1", "10", "'=cmd|' /C calc'!A0", `{"private":false}`, `{"private":true}`,
+		"422", OutcomeFailed, "'-1+1", "aa", "bb",
+	}
+	if !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("row = %q, want %q", rows[1], want)
+	}
+	// Unset optional columns are empty.
+	if row := rows[2]; row[8] != "" || row[10] != "" || row[11] != "" || row[12] != "" || row[3] != "" {
+		t.Errorf("row without optional fields = %q", row)
+	}
+	for _, row := range rows[1:] {
+		for i, cell := range row {
+			if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+				t.Errorf("column %s starts with a formula character: %q", csvHeader[i], cell)
+			}
+		}
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := map[string]string{
+		"":           "",
+		"octo/demo":  "octo/demo",
+		"=1+1":       "'=1+1",
+		"+1":         "'+1",
+		"-1":         "'-1",
+		"@SUM(A1)":   "'@SUM(A1)",
+		"\t=1+1":     "'\t=1+1",
+		"\r=1+1":     "'\r=1+1",
+		"a=b":        "a=b",
+		"'=already'": "'=already'",
+	}
+	for input, want := range tests {
+		if got := csvSafe(input); got != want {
+			t.Errorf("csvSafe(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	checkpointBucket = []byte("audit_checkpoints")
+	// metaBucket records where the hash chain starts and the newest
+	// checkpoint, so stripping the hashes off the whole chain or dropping
+	// the newest checkpoints along with the entries they cover is detected.
+	metaBucket        = []byte("audit_meta")
+	chainStartKey     = []byte("chain_start")
+	lastCheckpointKey = []byte("last_checkpoint")
+)
+
+// Keys sign audit checkpoints. They are kept apart from the rotating JWT
+// keys so checkpoints stay verifiable for as long as the log is kept.
+type Keys struct {
+	// Signer signs new checkpoints. Without it no checkpoints are written.
+	Signer ed25519.PrivateKey
+	// Previous are public keys of retired signers, so checkpoints they
+	// signed still verify.
+	Previous []ed25519.PublicKey
+}
+
+// KeysFromEnv reads the signing key from AUDIT_SIGNING_KEY_FILE or
+// AUDIT_SIGNING_KEY (a base64 Ed25519 seed) and retired public keys from
+// AUDIT_VERIFY_KEYS (comma separated base64).
+func KeysFromEnv() (*Keys, error) {
+	keys := &Keys{}
+
+	encoded := os.Getenv("AUDIT_SIGNING_KEY")
+	if path := os.Getenv("AUDIT_SIGNING_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit signing key: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded = strings.TrimSpace(encoded); encoded != "" {
+		seed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("audit signing key must be a base64 %d byte Ed25519 seed", ed25519.SeedSize)
+		}
+		keys.Signer = ed25519.NewKeyFromSeed(seed)
+	}
+
+	for _, value := range strings.Split(os.Getenv("AUDIT_VERIFY_KEYS"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		public, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(public) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid audit verify key %q", value)
+		}
+		keys.Previous = append(keys.Previous, ed25519.PublicKey(public))
+	}
+	return keys, nil
+}
+
+// SignerPublicKey returns the base64 public key of the signer, to add to
+// AUDIT_VERIFY_KEYS when it is replaced.
+func (k *Keys) SignerPublicKey() string {
+	return base64.StdEncoding.EncodeToString(k.Signer.Public().(ed25519.PublicKey))
+}
+
+// publicKeys returns every key checkpoints may be signed with, by key ID.
+func (k *Keys) publicKeys() map[string]ed25519.PublicKey {
+	public := make(map[string]ed25519.PublicKey)
+	if k == nil {
+		return public
+	}
+	if k.Signer != nil {
+		signer := k.Signer.Public().(ed25519.PublicKey)
+		public[keyID(signer)] = signer
+	}
+	for _, key := range k.Previous {
+		public[keyID(key)] = key
+	}
+	return public
+}
+
+func keyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// entryVersion is the encoding entries are stored in: the entry's JSON
+// exactly as it was hashed, next to the hash. Version 1 entries are plain
+// Entry JSON hashed as re-encoded from their fields, which a change to Entry
+// would break. Entries written before the hash chain existed have no hash.
+const entryVersion = 2
+
+type storedEntry struct {
+	Version int             `json:"v"`
+	Entry   json.RawMessage `json:"entry"`
+	Hash    string          `json:"hash"`
+}
+
+// encodeEntry fills in the hash of entry, which covers the hash of the entry
+// before it, and returns the bytes to store.
+func encodeEntry(entry *Entry) ([]byte, error) {
+	unhashed := *entry
+	unhashed.Hash = ""
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	entry.Hash = hashBytes(data)
+	return json.Marshal(storedEntry{Version: entryVersion, Entry: data, Hash: entry.Hash})
+}
+
+// decodeEntry decodes a stored entry of any version, along with the hash its
+// contents actually have, which is "" for entries from before the chain.
+func decodeEntry(data []byte) (*Entry, string, error) {
+	var stored storedEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, "", err
+	}
+
+	entry := &Entry{}
+	switch stored.Version {
+	case 0:
+		if err := json.Unmarshal(data, entry); err != nil {
+			return nil, "", err
+		}
+		if entry.Hash == "" {
+			return entry, "", nil
+		}
+		unhashed := *entry
+		unhashed.Hash = ""
+		encoded, err := json.Marshal(&unhashed)
+		if err != nil {
+			return nil, "", err
+		}
+		return entry, hashBytes(encoded), nil
+	case entryVersion:
+		if err := json.Unmarshal(stored.Entry, entry); err != nil {
+			return nil, "", err
+		}
+		entry.Hash = stored.Hash
+		return entry, hashBytes(stored.Entry), nil
+	}
+	return nil, "", fmt.Errorf("unknown audit entry version %d", stored.Version)
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastHash returns the hash of the newest entry, or "" for an empty log or
+// one with only entries from before the chain.
+func lastHash(bucket *bolt.Bucket) (string, error) {
+	key, data := bucket.Cursor().Last()
+	if key == nil {
+		return "", nil
+	}
+	last, _, err := decodeEntry(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode audit entry %d: %w", sequenceOf(key), err)
+	}
+	return last.Hash, nil
+}
+
+// chainStart returns the sequence of the first hashed entry, recording it
+// if it isn't yet, or 0 if there is no hashed entry. Entries that can't be
+// decoded are passed over; Verify reports them.
+func chainStart(tx *bolt.Tx) (uint64, error) {
+	meta := tx.Bucket(metaBucket)
+	if data := meta.Get(chainStartKey); data != nil {
+		return sequenceOf(data), nil
+	}
+	cursor := tx.Bucket(auditBucket).Cursor()
+	for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+		if _, hash, err := decodeEntry(data); err != nil || hash == "" {
+			continue
+		}
+		if err := meta.Put(chainStartKey, key); err != nil {
+			return 0, err
+		}
+		return sequenceOf(key), nil
+	}
+	return 0, nil
+}
+
+// checkpointVersion is the message checkpoints are signed over. Version 1
+// checkpoints sign only the entry they cover; version 2 ones also sign where
+// the chain starts and the checkpoint before them.
+const checkpointVersion = 2
+
+// Checkpoint is a signed statement of the newest entry's hash at some point
+// in time. Rewriting the log up to a checkpoint would need the signing key.
+// Each checkpoint links to the one before it, so none can be removed
+// without breaking the next.
+type Checkpoint struct {
+	Version    int       `json:"v,omitempty"`
+	Sequence   uint64    `json:"sequence"`
+	Hash       string    `json:"hash"`
+	ChainStart uint64    `json:"chain_start,omitempty"`
+	Previous   string    `json:"previous,omitempty"`
+	Time       time.Time `json:"time"`
+	KeyID      string    `json:"key_id"`
+	Signature  string    `json:"signature"`
+}
+
+func (c *Checkpoint) message() []byte {
+	if c.Version < checkpointVersion {
+		return []byte(fmt.Sprintf("audit-checkpoint:v1:%d:%s:%s", c.Sequence, c.Hash, c.Time.Format(time.RFC3339Nano)))
+	}
+	return []byte(fmt.Sprintf("audit-checkpoint:v2:%d:%s:%d:%s:%s", c.Sequence, c.Hash, c.ChainStart, c.Previous, c.Time.Format(time.RFC3339Nano)))
+}
+
+// digest is what the next checkpoint links to.
+func (c *Checkpoint) digest() string {
+	return hashBytes(append(c.message(), c.Signature...))
+}
+
+// Checkpoint signs the newest entry's hash, unless it already is. It does
+// nothing without a signing key or before the first hashed entry.
+func (l *Log) Checkpoint() (*Checkpoint, error) {
+	if l.keys == nil || l.keys.Signer == nil {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var checkpoint *Checkpoint
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		key, data := tx.Bucket(auditBucket).Cursor().Last()
+		if key == nil {
+			return nil
+		}
+		checkpoints := tx.Bucket(checkpointBucket)
+		last, lastData := checkpoints.Cursor().Last()
+		if last != nil && sequenceOf(last) == sequenceOf(key) {
+			return nil
+		}
+
+		entry, _, err := decodeEntry(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode audit entry %d: %w", sequenceOf(key), err)
+		}
+		if entry.Hash == "" {
+			return nil
+		}
+		start, err := chainStart(tx)
+		if err != nil {
+			return err
+		}
+		previous := ""
+		if last != nil {
+			var prev Checkpoint
+			if err := json.Unmarshal(lastData, &prev); err != nil {
+				return fmt.Errorf("failed to decode audit checkpoint %d: %w", sequenceOf(last), err)
+			}
+			previous = prev.digest()
+		}
+		checkpoint = &Checkpoint{
+			Version:    checkpointVersion,
+			Sequence:   entry.Sequence,
+			Hash:       entry.Hash,
+			ChainStart: start,
+			Previous:   previous,
+			Time:       time.Now().UTC(),
+			KeyID:      keyID(l.keys.Signer.Public().(ed25519.PublicKey)),
+		}
+		checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.keys.Signer, checkpoint.message()))
+
+		encoded, err := json.Marshal(checkpoint)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit checkpoint: %w", err)
+		}
+		if err := checkpoints.Put(key, encoded); err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put(lastCheckpointKey, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// StartCheckpointer writes a checkpoint on each interval until ctx is
+// cancelled.
+func (l *Log) StartCheckpointer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := l.Checkpoint(); err != nil {
+				log.Printf("Audit: failed to write checkpoint: %v", err)
+			}
+		}
+	}
+}
+
+// Verification is the result of checking the audit log.
+type Verification struct {
+	OK      bool `json:"ok"`
+	Entries int  `json:"entries"`
+	// Unhashed counts the entries from before the hash chain existed. They
+	// can only precede it and can't be verified.
+	Unhashed       int         `json:"unhashed,omitempty"`
+	Checkpoints    int         `json:"checkpoints"`
+	LastCheckpoint *Checkpoint `json:"last_checkpoint,omitempty"`
+	Broken         *BrokenLink `json:"broken,omitempty"`
+}
+
+// BrokenLink is the first entry that fails verification and why.
+type BrokenLink struct {
+	Sequence uint64 `json:"sequence"`
+	Reason   string `json:"reason"`
+}
+
+// Verify checks the log with the log's own keys.
+func (l *Log) Verify() (*Verification, error) {
+	return Verify(l.db, l.keys)
+}
+
+// Verify checks that every entry is present, unchanged and linked to the one
+// before it, and that every checkpoint is validly signed, matches the entry
+// it covers and links to the checkpoint before it. It stops at the first
+// broken link. The chain starts at the first hashed entry, which links to no
+// previous entry; once it has started, every entry must be hashed.
+func Verify(db *bolt.DB, keys *Keys) (*Verification, error) {
+	result := &Verification{}
+	public := keys.publicKeys()
+
+	err := db.View(func(tx *bolt.Tx) error {
+		var start, lastCheckpoint uint64
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			if data := meta.Get(chainStartKey); data != nil {
+				start = sequenceOf(data)
+			}
+			if data := meta.Get(lastCheckpointKey); data != nil {
+				lastCheckpoint = sequenceOf(data)
+			}
+		}
+
+		checkpoints := make(map[uint64]*Checkpoint)
+		if bucket := tx.Bucket(checkpointBucket); bucket != nil {
+			err := bucket.ForEach(func(key, data []byte) error {
+				checkpoint := &Checkpoint{}
+				if err := json.Unmarshal(data, checkpoint); err != nil {
+					return fmt.Errorf("failed to decode audit checkpoint %d: %w", sequenceOf(key), err)
+				}
+				checkpoints[sequenceOf(key)] = checkpoint
+				result.LastCheckpoint = checkpoint
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		result.Checkpoints = len(checkpoints)
+
+		next := uint64(1)
+		prevHash := ""
+		var prevCheckpoint *Checkpoint
+		if bucket := tx.Bucket(auditBucket); bucket != nil {
+			cursor := bucket.Cursor()
+			for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+				reason := verifyEntry(sequenceOf(key), data, next, &prevHash, start)
+				if checkpoint := checkpoints[sequenceOf(key)]; reason == "" && checkpoint != nil {
+					reason = verifyCheckpoint(checkpoint, prevHash, prevCheckpoint, start, public)
+					prevCheckpoint = checkpoint
+				}
+				if reason != "" {
+					result.Broken = &BrokenLink{Sequence: next, Reason: reason}
+					return nil
+				}
+				if prevHash == "" {
+					result.Unhashed++
+				}
+				result.Entries++
+				next++
+			}
+		}
+
+		// A checkpoint or chain start past the end means entries were cut
+		// off.
+		covered := lastCheckpoint
+		if last := result.LastCheckpoint; last != nil && last.Sequence > covered {
+			covered = last.Sequence
+		}
+		switch {
+		case covered >= next:
+			result.Broken = &BrokenLink{
+				Sequence: next,
+				Reason:   fmt.Sprintf("entry is missing: the log ends at %d but a checkpoint covers entry %d", next-1, covered),
+			}
+		case start >= next:
+			result.Broken = &BrokenLink{
+				Sequence: next,
+				Reason:   fmt.Sprintf("entry is missing: the log ends at %d but the hash chain starts at %d", next-1, start),
+			}
+		case lastCheckpoint != 0 && (result.LastCheckpoint == nil || result.LastCheckpoint.Sequence != lastCheckpoint):
+			result.Broken = &BrokenLink{
+				Sequence: lastCheckpoint,
+				Reason:   "checkpoint is missing",
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.OK = result.Broken == nil
+	return result, nil
+}
+
+// verifyEntry checks one entry, returning why it is broken or "" if it is
+// fine. prevHash is advanced to the entry's hash; it stays "" until the first
+// hashed entry, which must be the recorded start of the chain.
+func verifyEntry(sequence uint64, data []byte, expected uint64, prevHash *string, start uint64) string {
+	if sequence != expected {
+		return "entry is missing"
+	}
+
+	entry, hash, err := decodeEntry(data)
+	if err != nil {
+		return "entry cannot be decoded"
+	}
+	if entry.Sequence != sequence {
+		return fmt.Sprintf("entry is stored as %d but claims to be %d", sequence, entry.Sequence)
+	}
+	if hash == "" {
+		if *prevHash != "" || (start != 0 && sequence >= start) {
+			return "entry has no hash"
+		}
+		return ""
+	}
+	if *prevHash == "" && sequence != start {
+		if start == 0 {
+			return "the start of the hash chain is not recorded"
+		}
+		return fmt.Sprintf("the hash chain is recorded to start at %d", start)
+	}
+	if entry.PrevHash != *prevHash {
+		return "entry does not link to the previous entry"
+	}
+	if hash != entry.Hash {
+		return "entry contents do not match its hash"
+	}
+
+	*prevHash = entry.Hash
+	return ""
+}
+
+// verifyCheckpoint checks the checkpoint of the entry with the given hash,
+// returning why it is broken or "" if it is fine.
+func verifyCheckpoint(checkpoint *Checkpoint, hash string, previous *Checkpoint, start uint64, keys map[string]ed25519.PublicKey) string {
+	key, ok := keys[checkpoint.KeyID]
+	if !ok {
+		return fmt.Sprintf("checkpoint is signed by unknown key %s", checkpoint.KeyID)
+	}
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil || !ed25519.Verify(key, checkpoint.message(), signature) {
+		return "checkpoint signature is invalid"
+	}
+	if checkpoint.Hash != hash {
+		return "entry does not match the signed checkpoint"
+	}
+	if checkpoint.Version < checkpointVersion {
+		return ""
+	}
+
+	want := ""
+	if previous != nil {
+		want = previous.digest()
+	}
+	if checkpoint.Previous != want {
+		return "checkpoint does not link to the previous checkpoint"
+	}
+	if checkpoint.ChainStart != start {
+		return fmt.Sprintf("checkpoint was signed for a hash chain starting at %d", checkpoint.ChainStart)
+	}
+	return ""
+}
@@ -0,0 +1,463 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestLog(t *testing.T, keys *Keys) *Log {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	auditLog, err := New(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auditLog
+}
+
+func testSigner(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func appendEntries(t *testing.T, auditLog *Log, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		entry := &Entry{
+			Actor:    Actor{UserID: 1, Username: "octo"},
+			Action:   ActionUpdate,
+			FullName: "octo/demo",
+			Before:   State{"private": false, "topics": []string{"a<b"}, "stars": 12345678901},
+			After:    State{"private": true},
+			Outcome:  OutcomeSucceeded,
+		}
+		if err := auditLog.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// rewrite replaces the stored bytes of entry seq.
+func rewrite(t *testing.T, db *bolt.DB, seq uint64, change func(data []byte) []byte) {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+		data := append([]byte(nil), bucket.Get(sequenceKey(seq))...)
+		return bucket.Put(sequenceKey(seq), change(data))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remove(t *testing.T, db *bolt.DB, seq uint64) {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).Delete(sequenceKey(seq))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func removeCheckpoint(t *testing.T, db *bolt.DB, seq uint64) {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointBucket).Delete(sequenceKey(seq))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stripHash stores entry seq the way entries from before the chain were.
+func stripHash(t *testing.T, db *bolt.DB, seq uint64) {
+	t.Helper()
+	rewrite(t, db, seq, func(data []byte) []byte {
+		entry, _, err := decodeEntry(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Hash = ""
+		entry.PrevHash = ""
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	})
+}
+
+func TestVerifyIntactLog(t *testing.T) {
+	auditLog := newTestLog(t, &Keys{Signer: testSigner(1)})
+	appendEntries(t, auditLog, 3)
+	if _, err := auditLog.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	appendEntries(t, auditLog, 2)
+	checkpoint, err := auditLog.Checkpoint()
+	if err != nil || checkpoint == nil || checkpoint.Sequence != 5 {
+		t.Fatalf("Checkpoint = %+v, %v", checkpoint, err)
+	}
+	// Nothing new to sign.
+	if checkpoint, err := auditLog.Checkpoint(); err != nil || checkpoint != nil {
+		t.Errorf("repeated Checkpoint = %+v, %v", checkpoint, err)
+	}
+
+	result, err := auditLog.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK || result.Entries != 5 || result.Checkpoints != 2 || result.Unhashed != 0 {
+		t.Errorf("Verify = %+v, broken %+v", result, result.Broken)
+	}
+
+	entries, total, err := auditLog.Query(Filter{}, 0, 0)
+	if err != nil || total != 5 || entries[0].Sequence != 5 || entries[0].Hash != checkpoint.Hash || entries[0].PrevHash != entries[1].Hash {
+		t.Errorf("Query = %+v, %d, %v", entries, total, err)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, auditLog *Log)
+		broken uint64
+		reason string
+	}{
+		{
+			name: "edited entry",
+			tamper: func(t *testing.T, auditLog *Log) {
+				rewrite(t, auditLog.db, 2, func(data []byte) []byte {
+					return bytes.Replace(data, []byte(`octo/demo`), []byte(`octo/other`), 1)
+				})
+			},
+			broken: 2,
+			reason: "do not match its hash",
+		},
+		{
+			name: "edited and rehashed entry",
+			tamper: func(t *testing.T, auditLog *Log) {
+				rewrite(t, auditLog.db, 2, func(data []byte) []byte {
+					entry, _, err := decodeEntry(data)
+					if err != nil {
+						t.Fatal(err)
+					}
+					entry.Outcome = OutcomeFailed
+					encoded, err := encodeEntry(entry)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return encoded
+				})
+			},
+			broken: 3,
+			reason: "does not link",
+		},
+		{
+			name:   "removed entry",
+			tamper: func(t *testing.T, auditLog *Log) { remove(t, auditLog.db, 2) },
+			broken: 2,
+			reason: "missing",
+		},
+		{
+			name: "removed checkpointed tail",
+			tamper: func(t *testing.T, auditLog *Log) {
+				remove(t, auditLog.db, 4)
+			},
+			broken: 4,
+			reason: "checkpoint covers entry 4",
+		},
+		{
+			name:   "stripped hash",
+			tamper: func(t *testing.T, auditLog *Log) { stripHash(t, auditLog.db, 1) },
+			broken: 1,
+			reason: "entry has no hash",
+		},
+		{
+			name: "stripped chain and checkpoints",
+			tamper: func(t *testing.T, auditLog *Log) {
+				for seq := uint64(1); seq <= 4; seq++ {
+					stripHash(t, auditLog.db, seq)
+				}
+				err := auditLog.db.Update(func(tx *bolt.Tx) error {
+					if err := tx.DeleteBucket(checkpointBucket); err != nil {
+						return err
+					}
+					return tx.Bucket(metaBucket).Delete(lastCheckpointKey)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			broken: 1,
+			reason: "entry has no hash",
+		},
+		{
+			name: "unrecorded chain start",
+			tamper: func(t *testing.T, auditLog *Log) {
+				err := auditLog.db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket(metaBucket).Delete(chainStartKey)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			broken: 1,
+			reason: "start of the hash chain is not recorded",
+		},
+		{
+			name: "forged checkpoint",
+			tamper: func(t *testing.T, auditLog *Log) {
+				err := auditLog.db.Update(func(tx *bolt.Tx) error {
+					bucket := tx.Bucket(checkpointBucket)
+					var checkpoint Checkpoint
+					if err := json.Unmarshal(bucket.Get(sequenceKey(4)), &checkpoint); err != nil {
+						return err
+					}
+					checkpoint.Time = checkpoint.Time.Add(time.Second)
+					data, err := json.Marshal(checkpoint)
+					if err != nil {
+						return err
+					}
+					return bucket.Put(sequenceKey(4), data)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			broken: 4,
+			reason: "signature is invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditLog := newTestLog(t, &Keys{Signer: testSigner(1)})
+			appendEntries(t, auditLog, 4)
+			if _, err := auditLog.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(t, auditLog)
+
+			result, err := auditLog.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.OK || result.Broken.Sequence != tt.broken || !strings.Contains(result.Broken.Reason, tt.reason) {
+				t.Errorf("Verify = %+v, broken %+v, want broken at %d: %s", result, result.Broken, tt.broken, tt.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyLinksCheckpoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, db *bolt.DB)
+		broken uint64
+		reason string
+	}{
+		{
+			name:   "removed checkpoint",
+			tamper: func(t *testing.T, db *bolt.DB) { removeCheckpoint(t, db, 4) },
+			broken: 6,
+			reason: "does not link to the previous checkpoint",
+		},
+		{
+			name:   "removed newest checkpoint",
+			tamper: func(t *testing.T, db *bolt.DB) { removeCheckpoint(t, db, 6) },
+			broken: 6,
+			reason: "checkpoint is missing",
+		},
+		{
+			name: "removed tail with its checkpoint",
+			tamper: func(t *testing.T, db *bolt.DB) {
+				remove(t, db, 6)
+				remove(t, db, 5)
+				removeCheckpoint(t, db, 6)
+			},
+			broken: 5,
+			reason: "checkpoint covers entry 6",
+		},
+		{
+			name: "moved chain start",
+			tamper: func(t *testing.T, db *bolt.DB) {
+				stripHash(t, db, 1)
+				stripHash(t, db, 2)
+				rewrite(t, db, 3, func(data []byte) []byte {
+					entry, _, err := decodeEntry(data)
+					if err != nil {
+						t.Fatal(err)
+					}
+					entry.PrevHash = ""
+					encoded, err := encodeEntry(entry)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return encoded
+				})
+				err := db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket(metaBucket).Put(chainStartKey, sequenceKey(3))
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			broken: 2,
+			reason: "does not match the signed checkpoint",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditLog := newTestLog(t, &Keys{Signer: testSigner(1)})
+			for i := 0; i < 3; i++ {
+				appendEntries(t, auditLog, 2)
+				if _, err := auditLog.Checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if result, err := auditLog.Verify(); err != nil || !result.OK {
+				t.Fatalf("Verify before tampering = %+v, %v", result, err)
+			}
+			tt.tamper(t, auditLog.db)
+
+			result, err := auditLog.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.OK || result.Broken.Sequence != tt.broken || !strings.Contains(result.Broken.Reason, tt.reason) {
+				t.Errorf("Verify = %+v, broken %+v, want broken at %d: %s", result, result.Broken, tt.broken, tt.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyCheckpointKeys(t *testing.T) {
+	old := testSigner(1)
+	auditLog := newTestLog(t, &Keys{Signer: old})
+	appendEntries(t, auditLog, 2)
+	if _, err := auditLog.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The signer was replaced and the old public key kept.
+	result, err := Verify(auditLog.db, &Keys{Signer: testSigner(2), Previous: []ed25519.PublicKey{old.Public().(ed25519.PublicKey)}})
+	if err != nil || !result.OK {
+		t.Errorf("Verify with the retired key = %+v, %v", result, err)
+	}
+	// Without the old key the checkpoint can't be trusted.
+	result, err = Verify(auditLog.db, &Keys{Signer: testSigner(2)})
+	if err != nil || result.OK || !strings.Contains(result.Broken.Reason, "unknown key") {
+		t.Errorf("Verify without the signing key = %+v, %v", result, err)
+	}
+	// Without a signer no checkpoints are written.
+	unsigned := newTestLog(t, nil)
+	appendEntries(t, unsigned, 1)
+	if checkpoint, err := unsigned.Checkpoint(); err != nil || checkpoint != nil {
+		t.Errorf("Checkpoint without a signer = %+v, %v", checkpoint, err)
+	}
+}
+
+// putLegacy stores entries the way earlier versions did: plain Entry JSON,
+// with no hash before the chain existed and a hash of the re-encoded fields
+// after.
+func putLegacy(t *testing.T, db *bolt.DB, hashed bool, prevHash string) string {
+	t.Helper()
+	var hash string
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry := Entry{
+			Actor:    Actor{UserID: 1, Username: "octo"},
+			Sequence: seq,
+			Time:     time.Now().UTC(),
+			Action:   ActionDelete,
+			FullName: "octo/old",
+			Outcome:  OutcomeSucceeded,
+		}
+		if hashed {
+			entry.PrevHash = prevHash
+			encoded, err := json.Marshal(&entry)
+			if err != nil {
+				return err
+			}
+			entry.Hash = hashBytes(encoded)
+			hash = entry.Hash
+		}
+		data, err := json.Marshal(&entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(sequenceKey(seq), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestVerifyLegacyEntries(t *testing.T) {
+	auditLog := newTestLog(t, &Keys{Signer: testSigner(1)})
+	putLegacy(t, auditLog.db, false, "")
+	putLegacy(t, auditLog.db, false, "")
+	// Only entries from before the chain: nothing to sign yet.
+	if checkpoint, err := auditLog.Checkpoint(); err != nil || checkpoint != nil {
+		t.Errorf("Checkpoint of unhashed entries = %+v, %v", checkpoint, err)
+	}
+	// The first hashed entry anchors the chain.
+	hash := putLegacy(t, auditLog.db, true, "")
+	putLegacy(t, auditLog.db, true, hash)
+	appendEntries(t, auditLog, 2)
+	if _, err := auditLog.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := auditLog.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK || result.Entries != 6 || result.Unhashed != 2 {
+		t.Fatalf("Verify = %+v, broken %+v", result, result.Broken)
+	}
+	entries, _, err := auditLog.Query(Filter{Action: ActionDelete}, 0, 0)
+	if err != nil || len(entries) != 4 {
+		t.Errorf("Query of old entries = %d, %v", len(entries), err)
+	}
+
+	// An unhashed entry inside the chain was tampered with.
+	rewrite(t, auditLog.db, 4, func(data []byte) []byte {
+		return bytes.Replace(data, []byte(`"hash":"`+hashOf(t, data)+`"`), []byte(`"hash":""`), 1)
+	})
+	result, err = auditLog.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK || result.Broken.Sequence != 4 || result.Broken.Reason != "entry has no hash" {
+		t.Errorf("Verify = %+v, broken %+v", result, result.Broken)
+	}
+}
+
+func hashOf(t *testing.T, data []byte) string {
+	t.Helper()
+	entry, _, err := decodeEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry.Hash
+}
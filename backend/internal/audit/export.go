@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteJSONLines writes one JSON object per line.
+func WriteJSONLines(w io.Writer, entries []*Entry) error {
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var csvHeader = []string{
+	"sequence", "time", "user_id", "username", "source_ip", "request_id",
+	"action", "job_id", "repository_id", "full_name", "before", "after",
+	"github_status", "outcome", "error", "prev_hash", "hash",
+}
+
+// WriteCSV writes a header row and one row per entry. Before and after are
+// JSON encoded.
+func WriteCSV(w io.Writer, entries []*Entry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := writer.Write(csvRow(entry)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvRow(entry *Entry) []string {
+	return []string{
+		strconv.FormatUint(entry.Sequence, 10),
+		entry.Time.Format(time.RFC3339Nano),
+		strconv.Itoa(entry.UserID),
+		csvSafe(entry.Username),
+		entry.SourceIP,
+		csvSafe(entry.RequestID),
+		entry.Action,
+		entry.JobID,
+		optionalInt(entry.RepositoryID),
+		csvSafe(entry.FullName),
+		stateJSON(entry.Before),
+		stateJSON(entry.After),
+		optionalInt(entry.GitHubStatus),
+		entry.Outcome,
+		csvSafe(entry.Error),
+		entry.PrevHash,
+		entry.Hash,
+	}
+}
+
+func stateJSON(state State) string {
+	if state == nil {
+		return ""
+	}
+	data, _ := json.Marshal(state)
+	return string(data)
+}
+
+func optionalInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// csvSafe keeps spreadsheet applications from evaluating user-controlled
+// cells as formulas. Leading tabs and carriage returns are escaped too, as
+// some applications strip them before looking for a formula.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultConfirmationTTL = 5 * time.Minute
+
+var ErrInvalidConfirmation = errors.New("invalid or expired confirmation token")
+
+// ConfirmationTarget is a repository a confirmation token was issued for.
+type ConfirmationTarget struct {
+	ID       int    `json:"id"`
+	FullName string `json:"full_name"`
+}
+
+// confirmation is the server-side record behind a confirmation token. Only
+// a hash of the token is kept.
+type confirmation struct {
+	userID    int
+	action    string
+	targets   []ConfirmationTarget
+	expiresAt time.Time
+}
+
+type confirmationStore struct {
+	mu            sync.Mutex
+	confirmations map[string]*confirmation
+}
+
+var confirmations = &confirmationStore{confirmations: make(map[string]*confirmation)}
+
+// NewConfirmation issues a short-lived, single-use token that authorizes
+// action on exactly targets, for use after the user has seen a preview.
+func NewConfirmation(userID int, action string, targets []ConfirmationTarget) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(durationFromEnv("CONFIRMATION_TOKEN_TTL", defaultConfirmationTTL))
+	record := &confirmation{
+		userID:    userID,
+		action:    action,
+		targets:   append([]ConfirmationTarget(nil), targets...),
+		expiresAt: expiresAt,
+	}
+
+	confirmations.mu.Lock()
+	defer confirmations.mu.Unlock()
+
+	confirmations.sweep()
+	confirmations.confirmations[confirmationKey(token)] = record
+	return token, expiresAt, nil
+}
+
+// ConsumeConfirmation redeems a token for action on the repositories named
+// by fullNames, which must be exactly the list, in the same order, the token
+// was issued for. It
+// returns the targets, including the repository IDs seen in the preview, so
+// the caller can check it is still deleting the same repositories.
+func ConsumeConfirmation(token string, userID int, action string, fullNames []string) ([]ConfirmationTarget, error) {
+	confirmations.mu.Lock()
+	defer confirmations.mu.Unlock()
+
+	key := confirmationKey(token)
+	record, ok := confirmations.confirmations[key]
+	if !ok {
+		return nil, ErrInvalidConfirmation
+	}
+	// Tokens are single use, even when validation fails below.
+	delete(confirmations.confirmations, key)
+
+	if time.Now().After(record.expiresAt) || record.userID != userID || record.action != action {
+		return nil, ErrInvalidConfirmation
+	}
+
+	bound := make([]string, len(record.targets))
+	for i, target := range record.targets {
+		bound[i] = target.FullName
+	}
+	if subtle.ConstantTimeCompare(nameListDigest(bound), nameListDigest(fullNames)) != 1 {
+		return nil, fmt.Errorf("%w: repositories differ from the previewed ones", ErrInvalidConfirmation)
+	}
+	return record.targets, nil
+}
+
+func (s *confirmationStore) sweep() {
+	now := time.Now()
+	for key, record := range s.confirmations {
+		if now.After(record.expiresAt) {
+			delete(s.confirmations, key)
+		}
+	}
+}
+
+func confirmationKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return string(sum[:])
+}
+
+// nameListDigest hashes a list of repository names. GitHub names are case
+// insensitive; order and duplicates count, so the confirmed request is the
+// previewed one and can't grow by repeating a name.
+func nameListDigest(fullNames []string) []byte {
+	names := make([]string, len(fullNames))
+	for i, name := range fullNames {
+		names[i] = strings.ToLower(name)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(names, "\n")))
+	return sum[:]
+}
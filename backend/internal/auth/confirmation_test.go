@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var confirmationTargets = []ConfirmationTarget{
+	{ID: 1, FullName: "octo/one"},
+	{ID: 2, FullName: "octo/two"},
+}
+
+func TestConfirmationIsSingleUse(t *testing.T) {
+	token, expiresAt, err := NewConfirmation(1, "delete", confirmationTargets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresAt.Before(time.Now()) {
+		t.Errorf("token expires at %v", expiresAt)
+	}
+
+	// Names compare case insensitively.
+	targets, err := ConsumeConfirmation(token, 1, "delete", []string{"Octo/One", "octo/two"})
+	if err != nil || len(targets) != 2 || targets[0].ID != 1 || targets[1].ID != 2 {
+		t.Fatalf("ConsumeConfirmation = %+v, %v", targets, err)
+	}
+	if _, err := ConsumeConfirmation(token, 1, "delete", []string{"octo/one", "octo/two"}); !errors.Is(err, ErrInvalidConfirmation) {
+		t.Errorf("reused token: err = %v, want %v", err, ErrInvalidConfirmation)
+	}
+}
+
+func TestConfirmationIsBoundToTheRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		userID    int
+		action    string
+		fullNames []string
+	}{
+		{name: "other user", userID: 2, action: "delete", fullNames: []string{"octo/one", "octo/two"}},
+		{name: "other action", userID: 1, action: "archive", fullNames: []string{"octo/one", "octo/two"}},
+		{name: "other repository", userID: 1, action: "delete", fullNames: []string{"octo/one", "octo/three"}},
+		{name: "fewer repositories", userID: 1, action: "delete", fullNames: []string{"octo/one"}},
+		{name: "more repositories", userID: 1, action: "delete", fullNames: []string{"octo/one", "octo/two", "octo/three"}},
+		{name: "repeated repository", userID: 1, action: "delete", fullNames: []string{"octo/one", "octo/two", "octo/two"}},
+		{name: "reordered repositories", userID: 1, action: "delete", fullNames: []string{"octo/two", "octo/one"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := NewConfirmation(1, "delete", confirmationTargets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ConsumeConfirmation(token, tt.userID, tt.action, tt.fullNames); !errors.Is(err, ErrInvalidConfirmation) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidConfirmation)
+			}
+			// A failed attempt uses the token up.
+			if _, err := ConsumeConfirmation(token, 1, "delete", []string{"octo/one", "octo/two"}); !errors.Is(err, ErrInvalidConfirmation) {
+				t.Errorf("token reused after a failed attempt: err = %v", err)
+			}
+		})
+	}
+}
+
+func TestConfirmationExpires(t *testing.T) {
+	t.Setenv("CONFIRMATION_TOKEN_TTL", "10ms")
+	token, _, err := NewConfirmation(1, "delete", confirmationTargets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := ConsumeConfirmation(token, 1, "delete", []string{"octo/one", "octo/two"}); !errors.Is(err, ErrInvalidConfirmation) {
+		t.Errorf("expired token: err = %v, want %v", err, ErrInvalidConfirmation)
+	}
+	if _, err := ConsumeConfirmation("unknown", 1, "delete", []string{"octo/one", "octo/two"}); !errors.Is(err, ErrInvalidConfirmation) {
+		t.Errorf("unknown token: err = %v, want %v", err, ErrInvalidConfirmation)
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github-repo-manager/internal/secrets"
+)
+
+// EncryptedTokenStore wraps another TokenStore and keeps access tokens
+// envelope-encrypted in it, bound to the ID of the user they belong to.
+// Tokens written before encryption was enabled are still readable and get
+// encrypted by RotateKeys.
+type EncryptedTokenStore struct {
+	inner   TokenStore
+	keyring *secrets.Keyring
+
+	// mu serializes writes so a key rotation never overwrites a token saved
+	// concurrently by a new login.
+	mu sync.Mutex
+}
+
+func NewEncryptedTokenStore(inner TokenStore, keyring *secrets.Keyring) *EncryptedTokenStore {
+	return &EncryptedTokenStore{inner: inner, keyring: keyring}
+}
+
+func (s *EncryptedTokenStore) Save(token *UserToken) error {
+	envelope, err := s.keyring.Seal([]byte(token.AccessToken), tokenAssociatedData(token.UserID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt token: %w", err)
+	}
+
+	stored := *token
+	stored.AccessToken = envelope.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inner.Save(&stored)
+}
+
+func (s *EncryptedTokenStore) Get(userID int) (*UserToken, error) {
+	token, err := s.inner.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(token)
+}
+
+func (s *EncryptedTokenStore) Delete(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inner.Delete(userID)
+}
+
+func (s *EncryptedTokenStore) List() ([]*UserToken, error) {
+	tokens, err := s.inner.List()
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]*UserToken, 0, len(tokens))
+	for _, token := range tokens {
+		plain, err := s.decrypt(token)
+		if err != nil {
+			return nil, err
+		}
+		decrypted = append(decrypted, plain)
+	}
+	return decrypted, nil
+}
+
+// RotateKeys re-wraps every stored token whose data key is not wrapped by the
+// active master key, and encrypts any legacy plaintext tokens or tokens not
+// yet bound to their user. Reads keep working throughout since every
+// configured key can still decrypt.
+func (s *EncryptedTokenStore) RotateKeys() (int, error) {
+	tokens, err := s.inner.List()
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, token := range tokens {
+		changed, err := s.rotate(token.UserID)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to rotate token for user %d: %w", token.UserID, err)
+		}
+		if changed {
+			rotated++
+		}
+	}
+	return rotated, nil
+}
+
+func (s *EncryptedTokenStore) rotate(userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Re-read under the lock so we rotate the latest saved token.
+	token, err := s.inner.Get(userID)
+	if err != nil {
+		return false, err
+	}
+
+	var envelope *secrets.Envelope
+	if secrets.IsEnvelope(token.AccessToken) {
+		current, err := secrets.ParseEnvelope(token.AccessToken)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case current.Bound() && current.KeyID == s.keyring.ActiveKeyID():
+			return false, nil
+		case current.Bound():
+			if envelope, err = s.keyring.Rewrap(current); err != nil {
+				return false, err
+			}
+		default:
+			plaintext, err := s.keyring.Open(current, nil)
+			if err != nil {
+				return false, err
+			}
+			if envelope, err = s.keyring.Seal(plaintext, tokenAssociatedData(userID)); err != nil {
+				return false, err
+			}
+		}
+	} else {
+		if envelope, err = s.keyring.Seal([]byte(token.AccessToken), tokenAssociatedData(userID)); err != nil {
+			return false, err
+		}
+	}
+
+	token.AccessToken = envelope.String()
+	return true, s.inner.Save(token)
+}
+
+func (s *EncryptedTokenStore) decrypt(token *UserToken) (*UserToken, error) {
+	if !secrets.IsEnvelope(token.AccessToken) {
+		return token, nil
+	}
+
+	envelope, err := secrets.ParseEnvelope(token.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token for user %d: %w", token.UserID, err)
+	}
+	plaintext, err := s.keyring.Open(envelope, tokenAssociatedData(token.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token for user %d: %w", token.UserID, err)
+	}
+
+	token.AccessToken = string(plaintext)
+	return token, nil
+}
+
+// tokenAssociatedData binds a user's token to the user, so it can't be
+// decrypted as another user's.
+func tokenAssociatedData(userID int) []byte {
+	return []byte("user_token:" + strconv.Itoa(userID))
+}
+
+// RotateTokenEncryption re-encrypts all stored tokens with the active master
+// key. It is a no-op when token encryption is not enabled.
+func RotateTokenEncryption() {
+	store, ok := tokenStore.(*EncryptedTokenStore)
+	if !ok {
+		return
+	}
+
+	rotated, err := store.RotateKeys()
+	if err != nil {
+		log.Printf("Token key rotation stopped after %d tokens: %v", rotated, err)
+		return
+	}
+	if rotated > 0 {
+		log.Printf("Re-encrypted %d stored tokens with key %s", rotated, store.keyring.ActiveKeyID())
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github-repo-manager/internal/secrets"
+)
+
+func testKeyring(t *testing.T, activeID string, ids ...string) *secrets.Keyring {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[len(id)-1:]), 32)
+	}
+	keyring, err := secrets.NewKeyring(keys, activeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func storedEnvelope(t *testing.T, inner TokenStore, userID int) *secrets.Envelope {
+	t.Helper()
+	token, err := inner.Get(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := secrets.ParseEnvelope(token.AccessToken)
+	if err != nil {
+		t.Fatalf("stored token %q isn't an envelope: %v", token.AccessToken, err)
+	}
+	return envelope
+}
+
+func TestEncryptedTokenStore(t *testing.T) {
+	inner := NewMemoryTokenStore()
+	store := NewEncryptedTokenStore(inner, testKeyring(t, "k1", "k1"))
+
+	if err := store.Save(&UserToken{UserID: 1, Username: "octo", AccessToken: "gho_one"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := inner.Get(1)
+	if strings.Contains(raw.AccessToken, "gho_one") {
+		t.Fatalf("token stored in plaintext: %q", raw.AccessToken)
+	}
+	token, err := store.Get(1)
+	if err != nil || token.AccessToken != "gho_one" || token.Username != "octo" {
+		t.Errorf("Get(1) = %+v, %v", token, err)
+	}
+}
+
+func TestEncryptedTokenStoreBindsUser(t *testing.T) {
+	inner := NewMemoryTokenStore()
+	store := NewEncryptedTokenStore(inner, testKeyring(t, "k1", "k1"))
+	if err := store.Save(&UserToken{UserID: 1, AccessToken: "gho_one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone with write access to the database copies user 1's token to
+	// their own record.
+	raw, _ := inner.Get(1)
+	inner.Save(&UserToken{UserID: 2, AccessToken: raw.AccessToken})
+
+	if token, err := store.Get(2); err == nil {
+		t.Errorf("user 1's token decrypted as user 2's: %+v", token)
+	}
+}
+
+func TestEncryptedTokenStoreRotateKeys(t *testing.T) {
+	inner := NewMemoryTokenStore()
+	old := NewEncryptedTokenStore(inner, testKeyring(t, "k1", "k1"))
+	if err := old.Save(&UserToken{UserID: 1, AccessToken: "gho_one"}); err != nil {
+		t.Fatal(err)
+	}
+	// A token saved before encryption was enabled.
+	inner.Save(&UserToken{UserID: 2, AccessToken: "gho_two"})
+
+	store := NewEncryptedTokenStore(inner, testKeyring(t, "k2", "k1", "k2"))
+	if token, err := store.Get(1); err != nil || token.AccessToken != "gho_one" {
+		t.Fatalf("Get before rotation = %+v, %v", token, err)
+	}
+	if err := store.Save(&UserToken{UserID: 3, AccessToken: "gho_three"}); err != nil {
+		t.Fatal(err)
+	}
+	if keyID := storedEnvelope(t, inner, 3).KeyID; keyID != "k2" {
+		t.Errorf("new token sealed with %q, want k2", keyID)
+	}
+
+	rotated, err := store.RotateKeys()
+	if err != nil || rotated != 2 {
+		t.Fatalf("RotateKeys = %d, %v, want 2", rotated, err)
+	}
+	for userID, want := range map[int]string{1: "gho_one", 2: "gho_two", 3: "gho_three"} {
+		if envelope := storedEnvelope(t, inner, userID); envelope.KeyID != "k2" || !envelope.Bound() {
+			t.Errorf("token of user %d not rotated: %+v", userID, envelope)
+		}
+		if token, err := store.Get(userID); err != nil || token.AccessToken != want {
+			t.Errorf("Get(%d) after rotation = %+v, %v", userID, token, err)
+		}
+	}
+	if rotated, err := store.RotateKeys(); err != nil || rotated != 0 {
+		t.Errorf("second RotateKeys = %d, %v, want 0", rotated, err)
+	}
+
+	// The old key can now be dropped.
+	current := NewEncryptedTokenStore(inner, testKeyring(t, "k2", "k2"))
+	if tokens, err := current.List(); err != nil || len(tokens) != 3 {
+		t.Errorf("List without the old key = %v, %v", tokens, err)
+	}
+}
@@ -1,25 +1,16 @@
 package auth
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"log"
 	"os"
 
+	"github-repo-manager/internal/config"
+
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
 )
 
-type GitHubUser struct {
-	ID        int    `json:"id"`
-	Login     string `json:"login"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
-	HTMLURL   string `json:"html_url"`
-}
-
 type UserToken struct {
 	UserID      int    `json:"user_id"`
 	Username    string `json:"username"`
@@ -27,18 +18,19 @@ type UserToken struct {
 	TokenType   string `json:"token_type"`
 }
 
-// In-memory token storage (in production, use a database)
-var userTokens = make(map[int]*UserToken)
-
 var githubOAuthConfig *oauth2.Config
 
 func InitGitHubOAuth() {
+	endpoints := config.GitHub()
 	githubOAuthConfig = &oauth2.Config{
 		ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
 		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
 		RedirectURL:  fmt.Sprintf("%s/api/auth/callback", getBackendURL()),
 		Scopes:       []string{"repo", "user:email", "read:org", "delete_repo"},
-		Endpoint:     github.Endpoint,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  endpoints.AuthURL,
+			TokenURL: endpoints.TokenURL,
+		},
 	}
 }
 
@@ -49,27 +41,6 @@ func GetGitHubOAuthConfig() *oauth2.Config {
 	return githubOAuthConfig
 }
 
-func GetGitHubUser(token *oauth2.Token) (*GitHubUser, error) {
-	client := githubOAuthConfig.Client(context.Background(), token)
-	
-	resp, err := client.Get("https://api.github.com/user")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user info: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
-	}
-
-	var user GitHubUser
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, fmt.Errorf("failed to decode user info: %w", err)
-	}
-
-	return &user, nil
-}
-
 func getBackendURL() string {
 	backendURL := os.Getenv("BACKEND_URL")
 	if backendURL == "" {
@@ -82,27 +53,48 @@ func getBackendURL() string {
 	return backendURL
 }
 
-func StoreUserToken(userID int, username string, token *oauth2.Token) {
-	userTokens[userID] = &UserToken{
+func StoreUserToken(userID int, username string, token *oauth2.Token) error {
+	return tokenStore.Save(&UserToken{
 		UserID:      userID,
 		Username:    username,
 		AccessToken: token.AccessToken,
 		TokenType:   token.TokenType,
-	}
+	})
 }
 
 func GetUserToken(userID int) *UserToken {
-	return userTokens[userID]
+	userToken, err := tokenStore.Get(userID)
+	if err != nil {
+		if !errors.Is(err, ErrTokenNotFound) {
+			log.Printf("Failed to load token for user %d: %v", userID, err)
+		}
+		return nil
+	}
+	return userToken
 }
 
 func GetOAuthToken(userID int) *oauth2.Token {
-	userToken := userTokens[userID]
+	userToken := GetUserToken(userID)
 	if userToken == nil {
 		return nil
 	}
-	
+
 	return &oauth2.Token{
 		AccessToken: userToken.AccessToken,
 		TokenType:   userToken.TokenType,
 	}
-}
\ No newline at end of file
+}
+
+// ListUserIDs returns every user with a stored OAuth token.
+func ListUserIDs() ([]int, error) {
+	tokens, err := tokenStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]int, 0, len(tokens))
+	for _, token := range tokens {
+		userIDs = append(userIDs, token.UserID)
+	}
+	return userIDs, nil
+}
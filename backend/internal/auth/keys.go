@@ -0,0 +1,485 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github-repo-manager/internal/secrets"
+	"github-repo-manager/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultKeyRotationInterval = 7 * 24 * time.Hour
+	defaultKeyGracePeriod      = 24 * time.Hour
+	keyRotationCheckInterval   = time.Minute
+
+	// JWKSMaxAge is how long clients may cache the JWKS.
+	JWKSMaxAge = 5 * time.Minute
+	// keyPublishLead is how long the next key is in the JWKS before it
+	// signs: twice the max age, so even a cache refreshed late knows it.
+	keyPublishLead = 2 * JWKSMaxAge
+)
+
+// SigningKey is one asymmetric JWT signing key. Only the newest active key
+// signs; the next one is published in the JWKS from its creation but only
+// signs from ActivatesAt. Retired keys keep verifying until VerifyUntil so
+// tokens issued just before a rotation stay valid.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	CreatedAt   time.Time
+	ActivatesAt *time.Time
+	RetiredAt   *time.Time
+	VerifyUntil *time.Time
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// activeFrom returns when the key starts signing. Keys without ActivatesAt
+// sign from their creation.
+func (k *SigningKey) activeFrom() time.Time {
+	if k.ActivatesAt != nil {
+		return *k.ActivatesAt
+	}
+	return k.CreatedAt
+}
+
+func (k *SigningKey) usable(now time.Time) bool {
+	return k.VerifyUntil == nil || now.Before(*k.VerifyUntil)
+}
+
+// KeyManager owns the signing keys and rotates them on a schedule.
+type KeyManager struct {
+	mu          sync.RWMutex
+	keys        []*SigningKey
+	algorithm   string
+	interval    time.Duration
+	gracePeriod time.Duration
+	store       *signingKeyStore
+}
+
+var keyManager *KeyManager
+
+// InitSigningKeys loads the persisted signing keys (generating the first one
+// if needed). Keys are kept in the database sealed with the token encryption
+// keyring, or only in memory when TOKEN_STORE=memory.
+func InitSigningKeys(db *bolt.DB) error {
+	manager := &KeyManager{
+		algorithm:   signingAlgorithm(),
+		interval:    durationFromEnv("JWT_KEY_ROTATION_INTERVAL", defaultKeyRotationInterval),
+		gracePeriod: durationFromEnv("JWT_KEY_GRACE_PERIOD", defaultKeyGracePeriod),
+	}
+	if manager.gracePeriod < AccessTokenTTL() {
+		manager.gracePeriod = AccessTokenTTL()
+	}
+	if manager.algorithm != jwt.SigningMethodEdDSA.Alg() && manager.algorithm != jwt.SigningMethodRS256.Alg() {
+		return fmt.Errorf("unsupported JWT_SIGNING_ALG %q", manager.algorithm)
+	}
+
+	if os.Getenv("TOKEN_STORE") != "memory" {
+		keyring, err := secrets.LoadKeyring()
+		if err != nil {
+			return fmt.Errorf("failed to load encryption keys: %w", err)
+		}
+		if keyring == nil {
+			return fmt.Errorf("TOKEN_ENCRYPTION_KEYS or TOKEN_ENCRYPTION_KEYS_FILE must be set to persist signing keys")
+		}
+		store, err := newSigningKeyStore(db, keyring)
+		if err != nil {
+			return err
+		}
+		manager.store = store
+
+		if manager.keys, err = store.list(); err != nil {
+			return err
+		}
+	}
+
+	if err := manager.Rotate(); err != nil {
+		return err
+	}
+	keyManager = manager
+	return nil
+}
+
+// StartKeyRotation publishes the next signing key ahead of its rotation and
+// retires the current one once the next has taken over, until ctx is
+// cancelled.
+func StartKeyRotation(ctx context.Context) {
+	ticker := time.NewTicker(keyRotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := keyManager.Rotate(); err != nil {
+				log.Printf("JWT signing key rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+// Rotate generates a signing key if there is none, publishes the next key
+// keyPublishLead before the current one is due, retires the current key once
+// the next has started signing, and drops keys whose grace period has ended.
+func (m *KeyManager) Rotate() error {
+	return m.rotate(time.Now())
+}
+
+func (m *KeyManager) rotate(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.currentLocked(now)
+	if current == nil {
+		// No key has signed anything yet, so none can be cached either: the
+		// first key signs right away.
+		key, err := m.generateLocked(now, nil)
+		if err != nil {
+			return err
+		}
+		log.Printf("Generated JWT signing key %s (%s)", key.ID, key.Algorithm)
+		return m.pruneLocked(now)
+	}
+
+	for _, key := range m.keys {
+		if key == current || key.RetiredAt != nil || key.activeFrom().After(now) {
+			continue
+		}
+		verifyUntil := now.Add(m.gracePeriod)
+		key.RetiredAt = &now
+		key.VerifyUntil = &verifyUntil
+		if m.store != nil {
+			if err := m.store.save(key); err != nil {
+				return err
+			}
+		}
+		log.Printf("Rotated JWT signing key, new key ID %s (%s)", current.ID, current.Algorithm)
+	}
+
+	due := current.activeFrom().Add(m.interval)
+	if m.nextLocked(now) == nil && !now.Before(due.Add(-keyPublishLead)) {
+		activatesAt := due
+		if earliest := now.Add(keyPublishLead); activatesAt.Before(earliest) {
+			activatesAt = earliest
+		}
+		key, err := m.generateLocked(now, &activatesAt)
+		if err != nil {
+			return err
+		}
+		log.Printf("Published next JWT signing key %s, signing from %s", key.ID, activatesAt.Format(time.RFC3339))
+	}
+	return m.pruneLocked(now)
+}
+
+// generateLocked generates, saves and adds a key that signs from activatesAt,
+// or right away if it is nil.
+func (m *KeyManager) generateLocked(now time.Time, activatesAt *time.Time) (*SigningKey, error) {
+	key, err := generateSigningKey(m.algorithm, now)
+	if err != nil {
+		return nil, err
+	}
+	key.ActivatesAt = activatesAt
+	if m.store != nil {
+		if err := m.store.save(key); err != nil {
+			return nil, err
+		}
+	}
+	m.keys = append(m.keys, key)
+	return key, nil
+}
+
+func (m *KeyManager) pruneLocked(now time.Time) error {
+	kept := m.keys[:0]
+	for _, key := range m.keys {
+		if key.usable(now) {
+			kept = append(kept, key)
+			continue
+		}
+		if m.store != nil {
+			if err := m.store.delete(key.ID); err != nil {
+				return err
+			}
+		}
+	}
+	m.keys = kept
+	return nil
+}
+
+// currentLocked returns the newest non-retired key that is active at now.
+func (m *KeyManager) currentLocked(now time.Time) *SigningKey {
+	var current *SigningKey
+	for _, key := range m.keys {
+		if key.RetiredAt != nil || key.activeFrom().After(now) {
+			continue
+		}
+		if current == nil || key.activeFrom().After(current.activeFrom()) {
+			current = key
+		}
+	}
+	return current
+}
+
+// nextLocked returns the published key that starts signing after now, if
+// any.
+func (m *KeyManager) nextLocked(now time.Time) *SigningKey {
+	for _, key := range m.keys {
+		if key.RetiredAt == nil && key.activeFrom().After(now) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (m *KeyManager) signingKey() (*SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	current := m.currentLocked(time.Now())
+	if current == nil {
+		return nil, fmt.Errorf("no JWT signing key available")
+	}
+	return current, nil
+}
+
+func (m *KeyManager) verificationKey(kid string) (*SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range m.keys {
+		if key.ID == kid && key.usable(now) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS returns the public half of every key that can still verify tokens,
+// including the next key before it starts signing.
+func JWKS() []JWK {
+	return keyManager.jwks(time.Now())
+}
+
+func (m *KeyManager) jwks(now time.Time) []JWK {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jwks := make([]JWK, 0, len(m.keys))
+	for _, key := range m.keys {
+		if !key.usable(now) {
+			continue
+		}
+
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.PrivateKey.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].KeyID < jwks[j].KeyID })
+	return jwks
+}
+
+func generateSigningKey(algorithm string, now time.Time) (*SigningKey, error) {
+	var signer crypto.Signer
+	switch algorithm {
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		signer = private
+	case jwt.SigningMethodRS256.Alg():
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		signer = private
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	id, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, Algorithm: algorithm, PrivateKey: signer, CreatedAt: now}, nil
+}
+
+func signingAlgorithm() string {
+	if algorithm := os.Getenv("JWT_SIGNING_ALG"); algorithm != "" {
+		return algorithm
+	}
+	return jwt.SigningMethodEdDSA.Alg()
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(name)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+var signingKeysBucket = []byte("jwt_signing_keys")
+
+type storedSigningKey struct {
+	ID          string     `json:"id"`
+	Algorithm   string     `json:"alg"`
+	PrivateKey  string     `json:"private_key"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatesAt *time.Time `json:"activates_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+	VerifyUntil *time.Time `json:"verify_until,omitempty"`
+}
+
+// signingKeyStore persists signing keys with their private halves sealed by
+// the keyring.
+type signingKeyStore struct {
+	db      *bolt.DB
+	keyring *secrets.Keyring
+}
+
+func newSigningKeyStore(db *bolt.DB, keyring *secrets.Keyring) (*signingKeyStore, error) {
+	if err := storage.EnsureBuckets(db, signingKeysBucket); err != nil {
+		return nil, fmt.Errorf("failed to initialize signing key store: %w", err)
+	}
+	return &signingKeyStore{db: db, keyring: keyring}, nil
+}
+
+func (s *signingKeyStore) save(key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	envelope, err := s.keyring.Seal(der, signingKeyAssociatedData(key.ID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	data, err := json.Marshal(storedSigningKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  envelope.String(),
+		CreatedAt:   key.CreatedAt,
+		ActivatesAt: key.ActivatesAt,
+		RetiredAt:   key.RetiredAt,
+		VerifyUntil: key.VerifyUntil,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode signing key: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(signingKeysBucket).Put([]byte(key.ID), data)
+	})
+}
+
+func (s *signingKeyStore) list() ([]*SigningKey, error) {
+	var stored []storedSigningKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(signingKeysBucket).ForEach(func(_, data []byte) error {
+			var record storedSigningKey
+			if err := json.Unmarshal(data, &record); err != nil {
+				return fmt.Errorf("failed to decode signing key: %w", err)
+			}
+			stored = append(stored, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(stored))
+	for _, record := range stored {
+		envelope, err := secrets.ParseEnvelope(record.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %s: %w", record.ID, err)
+		}
+		der, err := s.keyring.Open(envelope, signingKeyAssociatedData(record.ID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt signing key %s: %w", record.ID, err)
+		}
+		private, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %s: %w", record.ID, err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not a signer", record.ID)
+		}
+
+		key := &SigningKey{
+			ID:          record.ID,
+			Algorithm:   record.Algorithm,
+			PrivateKey:  signer,
+			CreatedAt:   record.CreatedAt,
+			ActivatesAt: record.ActivatesAt,
+			RetiredAt:   record.RetiredAt,
+			VerifyUntil: record.VerifyUntil,
+		}
+		// Re-seal keys still wrapped by a previous master key or not yet
+		// bound to their ID
+		if envelope.KeyID != s.keyring.ActiveKeyID() || !envelope.Bound() {
+			if err := s.save(key); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// signingKeyAssociatedData binds a sealed private key to its key ID.
+func signingKeyAssociatedData(id string) []byte {
+	return []byte("jwt_signing_key:" + id)
+}
+
+func (s *signingKeyStore) delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(signingKeysBucket).Delete([]byte(id))
+	})
+}
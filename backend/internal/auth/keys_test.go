@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func published(m *KeyManager, now time.Time, id string) bool {
+	for _, jwk := range m.jwks(now) {
+		if jwk.KeyID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func rotateAt(t *testing.T, m *KeyManager, now time.Time) {
+	t.Helper()
+	if err := m.rotate(now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextKeyIsPublishedBeforeItSigns(t *testing.T) {
+	m := &KeyManager{algorithm: jwt.SigningMethodEdDSA.Alg(), interval: time.Hour, gracePeriod: 15 * time.Minute}
+	start := time.Now()
+
+	rotateAt(t, m, start)
+	first := m.currentLocked(start)
+	if first == nil || len(m.keys) != 1 || !published(m, start, first.ID) {
+		t.Fatalf("first key isn't signing and published: %+v", m.keys)
+	}
+
+	// Not yet time to publish the next key.
+	rotateAt(t, m, start.Add(time.Hour-keyPublishLead-time.Minute))
+	if len(m.keys) != 1 {
+		t.Fatalf("next key published %s early", keyPublishLead+time.Minute)
+	}
+
+	publishedAt := start.Add(time.Hour - keyPublishLead)
+	rotateAt(t, m, publishedAt)
+	next := m.nextLocked(publishedAt)
+	if next == nil || !published(m, publishedAt, next.ID) {
+		t.Fatalf("next key isn't published: %+v", m.keys)
+	}
+	if m.currentLocked(publishedAt) != first {
+		t.Error("next key signs as soon as it is published")
+	}
+	if lead := next.activeFrom().Sub(next.CreatedAt); lead < JWKSMaxAge {
+		t.Errorf("next key signs %s after it is published, less than the JWKS max age", lead)
+	}
+
+	// Just before it is due the current key still signs; from then on the
+	// next one does, even before the rotation check runs.
+	if m.currentLocked(start.Add(time.Hour-time.Second)) != first {
+		t.Error("current key replaced before it is due")
+	}
+	due := start.Add(time.Hour)
+	if m.currentLocked(due) != next {
+		t.Error("next key doesn't sign once the current one is due")
+	}
+
+	rotateAt(t, m, due)
+	if first.RetiredAt == nil || !first.VerifyUntil.Equal(due.Add(15*time.Minute)) {
+		t.Errorf("previous key not retired with a grace period: %+v", first)
+	}
+	if next.RetiredAt != nil || !published(m, due, first.ID) || !published(m, due, next.ID) {
+		t.Errorf("unexpected keys after rotation: %+v", m.jwks(due))
+	}
+
+	afterGrace := due.Add(16 * time.Minute)
+	rotateAt(t, m, afterGrace)
+	if published(m, afterGrace, first.ID) || len(m.keys) != 1 {
+		t.Errorf("retired key kept after its grace period: %+v", m.jwks(afterGrace))
+	}
+}
+
+func TestOverdueKeyRotatesAfterPublishing(t *testing.T) {
+	m := &KeyManager{algorithm: jwt.SigningMethodEdDSA.Alg(), interval: time.Hour, gracePeriod: 15 * time.Minute}
+	start := time.Now()
+	rotateAt(t, m, start)
+	first := m.currentLocked(start)
+
+	// The backend was down when the key was due: the old key keeps signing
+	// until the new one has been published for keyPublishLead.
+	restart := start.Add(10 * time.Hour)
+	rotateAt(t, m, restart)
+	next := m.nextLocked(restart)
+	if next == nil || m.currentLocked(restart) != first {
+		t.Fatalf("overdue key replaced without publishing the next one first: %+v", m.keys)
+	}
+	if !next.activeFrom().Equal(restart.Add(keyPublishLead)) {
+		t.Errorf("next key signs from %s, want %s", next.activeFrom(), restart.Add(keyPublishLead))
+	}
+}
+
+func TestNextKeyIsPersisted(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := newSigningKeyStore(db, testKeyring(t, "k1", "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &KeyManager{algorithm: jwt.SigningMethodEdDSA.Alg(), interval: time.Hour, gracePeriod: 15 * time.Minute, store: store}
+	start := time.Now()
+	rotateAt(t, m, start)
+	rotateAt(t, m, start.Add(time.Hour-keyPublishLead))
+	next := m.nextLocked(start)
+
+	keys, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := &KeyManager{keys: keys}
+	saved := reloaded.nextLocked(start)
+	if saved == nil || saved.ID != next.ID || !saved.activeFrom().Equal(next.activeFrom()) {
+		t.Errorf("next key not restored: %+v", keys)
+	}
+	if current := reloaded.currentLocked(start); current == nil || current.ID == next.ID {
+		t.Errorf("restored current key = %+v", current)
+	}
+}
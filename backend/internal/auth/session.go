@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github-repo-manager/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session revoked")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// Session is a login of one user on one device. Access tokens carry its ID,
+// and it holds the hash of the only refresh token currently valid for it.
+type Session struct {
+	ID               string     `json:"id"`
+	UserID           int        `json:"user_id"`
+	Username         string     `json:"username"`
+	RefreshHash      string     `json:"refresh_hash"`
+	PreviousHash     string     `json:"previous_hash,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastRefreshedAt  time.Time  `json:"last_refreshed_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+}
+
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionStore persists sessions.
+type SessionStore interface {
+	Save(session *Session) error
+	Get(id string) (*Session, error)
+	ListByUser(userID int) ([]*Session, error)
+	Delete(id string) error
+}
+
+var (
+	sessionStore SessionStore = NewMemorySessionStore()
+	// sessionMu serializes session read-modify-write cycles such as refresh
+	// token rotation.
+	sessionMu sync.Mutex
+)
+
+// InitSessionStore selects the session store with the same TOKEN_STORE
+// setting as the OAuth token store.
+func InitSessionStore(db *bolt.DB) error {
+	switch backend := os.Getenv("TOKEN_STORE"); backend {
+	case "", "bolt":
+		store, err := NewBoltSessionStore(db)
+		if err != nil {
+			return err
+		}
+		SetSessionStore(store)
+	case "memory":
+		SetSessionStore(NewMemorySessionStore())
+	default:
+		return fmt.Errorf("unknown TOKEN_STORE %q", backend)
+	}
+	return nil
+}
+
+// SetSessionStore replaces the session store, e.g. with a fake in tests.
+func SetSessionStore(store SessionStore) {
+	sessionStore = store
+}
+
+// CreateSession starts a session for a freshly logged-in user and returns
+// its first access and refresh tokens.
+func CreateSession(userID int, username string) (accessToken, refreshToken string, err error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:              sessionID,
+		UserID:          userID,
+		Username:        username,
+		RefreshHash:     hashRefreshSecret(secret),
+		CreatedAt:       now,
+		LastRefreshedAt: now,
+		ExpiresAt:       now.Add(RefreshTokenTTL()),
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	pruneSessions(userID, now)
+	if err := sessionStore.Save(session); err != nil {
+		return "", "", fmt.Errorf("failed to save session: %w", err)
+	}
+
+	accessToken, err = GenerateJWT(userID, username, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, sessionID + "." + secret, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new
+// refresh token. Presenting an already rotated refresh token means it was
+// copied, so the whole session is revoked.
+func RefreshSession(refreshToken string) (accessToken, newRefreshToken string, err error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	session, err := sessionStore.Get(sessionID)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return "", "", ErrInvalidRefreshToken
+		}
+		return "", "", err
+	}
+
+	now := time.Now()
+	if !session.Active(now) {
+		return "", "", ErrSessionRevoked
+	}
+
+	presented := hashRefreshSecret(secret)
+	if session.PreviousHash != "" && hashesEqual(presented, session.PreviousHash) {
+		revoke(session, now, "refresh token reuse detected")
+		if err := sessionStore.Save(session); err != nil {
+			return "", "", fmt.Errorf("failed to revoke session: %w", err)
+		}
+		return "", "", ErrSessionRevoked
+	}
+	if !hashesEqual(presented, session.RefreshHash) {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	nextSecret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	session.PreviousHash = session.RefreshHash
+	session.RefreshHash = hashRefreshSecret(nextSecret)
+	session.LastRefreshedAt = now
+	if err := sessionStore.Save(session); err != nil {
+		return "", "", fmt.Errorf("failed to save session: %w", err)
+	}
+
+	accessToken, err = GenerateJWT(session.UserID, session.Username, session.ID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, session.ID + "." + nextSecret, nil
+}
+
+// RevokeSession ends a single session, e.g. on logout.
+func RevokeSession(sessionID string) error {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	session, err := sessionStore.Get(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return nil
+	}
+	revoke(session, time.Now(), "logout")
+	return sessionStore.Save(session)
+}
+
+// RevokeAllSessions ends every session of a user ("log out everywhere") and
+// returns how many were still active.
+func RevokeAllSessions(userID int) (int, error) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	sessions, err := sessionStore.ListByUser(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	revoked := 0
+	for _, session := range sessions {
+		if !session.Active(now) {
+			continue
+		}
+		revoke(session, now, "logout everywhere")
+		if err := sessionStore.Save(session); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// IsSessionActive is the revocation check applied to every access token.
+func IsSessionActive(sessionID string) bool {
+	session, err := sessionStore.Get(sessionID)
+	if err != nil {
+		return false
+	}
+	return session.Active(time.Now())
+}
+
+func revoke(session *Session, now time.Time, reason string) {
+	session.RevokedAt = &now
+	session.RevocationReason = reason
+}
+
+// pruneSessions drops a user's sessions that can no longer be used. Callers
+// hold sessionMu.
+func pruneSessions(userID int, now time.Time) {
+	sessions, err := sessionStore.ListByUser(userID)
+	if err != nil {
+		return
+	}
+	for _, session := range sessions {
+		if now.After(session.ExpiresAt) || (session.RevokedAt != nil && now.Sub(*session.RevokedAt) > AccessTokenTTL()) {
+			sessionStore.Delete(session.ID)
+		}
+	}
+}
+
+// RefreshTokenTTL is how long a session lasts without logging in again.
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashesEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// MemorySessionStore keeps sessions in process memory.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]*Session)}
+}
+
+func (s *MemorySessionStore) Save(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *session
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (s *MemorySessionStore) Get(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (s *MemorySessionStore) ListByUser(userID int) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]*Session, 0)
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+var sessionsBucket = []byte("sessions")
+
+// BoltSessionStore keeps sessions in the embedded bbolt database.
+type BoltSessionStore struct {
+	db *bolt.DB
+}
+
+func NewBoltSessionStore(db *bolt.DB) (*BoltSessionStore, error) {
+	if err := storage.EnsureBuckets(db, sessionsBucket); err != nil {
+		return nil, fmt.Errorf("failed to initialize session store: %w", err)
+	}
+	return &BoltSessionStore{db: db}, nil
+}
+
+func (s *BoltSessionStore) Save(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(session.ID), data)
+	})
+}
+
+func (s *BoltSessionStore) Get(id string) (*Session, error) {
+	var session *Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrSessionNotFound
+		}
+		session = &Session{}
+		return json.Unmarshal(data, session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *BoltSessionStore) ListByUser(userID int) ([]*Session, error) {
+	sessions := make([]*Session, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(_, data []byte) error {
+			var session Session
+			if err := json.Unmarshal(data, &session); err != nil {
+				return fmt.Errorf("failed to decode session: %w", err)
+			}
+			if session.UserID == userID {
+				sessions = append(sessions, &session)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *BoltSessionStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// useMemorySessions gives the test in-memory sessions and signing keys.
+func useMemorySessions(t *testing.T) {
+	t.Helper()
+	t.Setenv("TOKEN_STORE", "memory")
+	if err := InitSigningKeys(nil); err != nil {
+		t.Fatal(err)
+	}
+	previous := sessionStore
+	SetSessionStore(NewMemorySessionStore())
+	t.Cleanup(func() { SetSessionStore(previous) })
+}
+
+func TestRefreshSessionRotates(t *testing.T) {
+	useMemorySessions(t)
+
+	_, refreshToken, err := CreateSession(1, "octo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, rotated, err := RefreshSession(refreshToken)
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if rotated == refreshToken {
+		t.Error("refresh token wasn't rotated")
+	}
+	claims, err := ValidateAccessToken(accessToken)
+	if err != nil || claims.UserID != 1 || claims.Username != "octo" {
+		t.Errorf("ValidateAccessToken = %+v, %v", claims, err)
+	}
+	if _, _, err := RefreshSession(rotated); err != nil {
+		t.Errorf("RefreshSession with the rotated token: %v", err)
+	}
+}
+
+func TestRefreshSessionReuseRevokesSession(t *testing.T) {
+	useMemorySessions(t)
+
+	_, stolen, err := CreateSession(1, "octo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, current, err := RefreshSession(stolen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := RefreshSession(stolen); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("replayed refresh token: err = %v, want %v", err, ErrSessionRevoked)
+	}
+	// The legitimate holder is logged out too: the copy can't be told apart.
+	if _, _, err := RefreshSession(current); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("current refresh token after reuse: err = %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, err := ValidateAccessToken(accessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("access token after reuse: err = %v, want %v", err, ErrSessionRevoked)
+	}
+}
+
+func TestRefreshSessionInvalidTokens(t *testing.T) {
+	useMemorySessions(t)
+
+	_, refreshToken, err := CreateSession(1, "octo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID, _, _ := strings.Cut(refreshToken, ".")
+	for _, token := range []string{"", "nodot", sessionID + ".", "unknown.secret", sessionID + ".wrong"} {
+		if _, _, err := RefreshSession(token); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession(%q): err = %v, want %v", token, err, ErrInvalidRefreshToken)
+		}
+	}
+	// A wrong secret doesn't revoke the session.
+	if _, _, err := RefreshSession(refreshToken); err != nil {
+		t.Errorf("RefreshSession after invalid attempts: %v", err)
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	useMemorySessions(t)
+
+	first, _, err := CreateSession(1, "octo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := CreateSession(1, "octo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := CreateSession(2, "hubot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, err := RevokeAllSessions(1); err != nil || revoked != 2 {
+		t.Fatalf("RevokeAllSessions = %d, %v, want 2", revoked, err)
+	}
+	for _, token := range []string{first, second} {
+		if _, err := ValidateAccessToken(token); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("access token of a revoked session: err = %v", err)
+		}
+	}
+	if _, err := ValidateAccessToken(other); err != nil {
+		t.Errorf("another user's session was revoked: %v", err)
+	}
+}
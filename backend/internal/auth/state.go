@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const loginStateTTL = 10 * time.Minute
+
+var ErrInvalidState = errors.New("invalid or expired OAuth state")
+
+// LoginState is the server-side record behind an OAuth state parameter.
+type LoginState struct {
+	Verifier  string
+	ReturnTo  string
+	binding   []byte
+	expiresAt time.Time
+}
+
+type loginStateStore struct {
+	mu     sync.Mutex
+	states map[string]*LoginState
+}
+
+var loginStates = &loginStateStore{states: make(map[string]*LoginState)}
+
+// NewLoginState starts an OAuth login. It returns the state to send to
+// GitHub, the PKCE verifier for the code exchange, and a browser binding
+// value the caller must set as a cookie; the state is only accepted back
+// together with that cookie.
+func NewLoginState(returnTo string) (state string, loginState *LoginState, binding string, err error) {
+	if state, err = randomToken(32); err != nil {
+		return "", nil, "", err
+	}
+	if binding, err = randomToken(32); err != nil {
+		return "", nil, "", err
+	}
+
+	loginState = &LoginState{
+		Verifier:  oauth2.GenerateVerifier(),
+		ReturnTo:  SanitizeReturnPath(returnTo),
+		binding:   hashBinding(binding),
+		expiresAt: time.Now().Add(loginStateTTL),
+	}
+
+	loginStates.mu.Lock()
+	defer loginStates.mu.Unlock()
+
+	loginStates.sweep()
+	loginStates.states[state] = loginState
+	return state, loginState, binding, nil
+}
+
+// ConsumeLoginState returns and forgets the login started with state. It
+// fails if the state is unknown, expired, or not bound to this browser.
+func ConsumeLoginState(state, binding string) (*LoginState, error) {
+	loginStates.mu.Lock()
+	defer loginStates.mu.Unlock()
+
+	loginState, ok := loginStates.states[state]
+	if !ok {
+		return nil, ErrInvalidState
+	}
+	// States are single use, even when validation fails below.
+	delete(loginStates.states, state)
+
+	if time.Now().After(loginState.expiresAt) {
+		return nil, ErrInvalidState
+	}
+	if subtle.ConstantTimeCompare(loginState.binding, hashBinding(binding)) != 1 {
+		return nil, ErrInvalidState
+	}
+	return loginState, nil
+}
+
+func (s *loginStateStore) sweep() {
+	now := time.Now()
+	for state, loginState := range s.states {
+		if now.After(loginState.expiresAt) {
+			delete(s.states, state)
+		}
+	}
+}
+
+// SanitizeReturnPath only allows local absolute paths so the post-login
+// redirect can't be turned into an open redirect.
+func SanitizeReturnPath(returnTo string) string {
+	if returnTo == "" || !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		return "/dashboard"
+	}
+	// Browsers treat backslashes like slashes and drop tabs and newlines,
+	// either of which can turn "/\evil.com" into "//evil.com".
+	for _, r := range returnTo {
+		if r == '\\' || r < 0x20 || r == 0x7f {
+			return "/dashboard"
+		}
+	}
+
+	parsed, err := url.Parse(returnTo)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" {
+		return "/dashboard"
+	}
+	return returnTo
+}
+
+func hashBinding(binding string) []byte {
+	sum := sha256.Sum256([]byte(binding))
+	return sum[:]
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
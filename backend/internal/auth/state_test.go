@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSanitizeReturnPath(t *testing.T) {
+	tests := map[string]string{
+		"":                         "/dashboard",
+		"/dashboard":               "/dashboard",
+		"/repos/octo?tab=settings": "/repos/octo?tab=settings",
+		"/a/b#section":             "/a/b#section",
+		"//evil.com":               "/dashboard",
+		"///evil.com":              "/dashboard",
+		`/\evil.com`:               "/dashboard",
+		`\\evil.com`:               "/dashboard",
+		"/\t/evil.com":             "/dashboard",
+		"/\n/evil.com":             "/dashboard",
+		"https://evil.com":         "/dashboard",
+		"http://localhost:3000/x":  "/dashboard",
+		"javascript:alert(1)":      "/dashboard",
+		"evil.com":                 "/dashboard",
+		"dashboard":                "/dashboard",
+		"/%2F%2Fevil.com":          "/%2F%2Fevil.com",
+		"/redirect?to=//evil.com":  "/redirect?to=//evil.com",
+	}
+	for input, want := range tests {
+		if got := SanitizeReturnPath(input); got != want {
+			t.Errorf("SanitizeReturnPath(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestLoginState(t *testing.T) {
+	state, loginState, binding, err := NewLoginState("//evil.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loginState.ReturnTo != "/dashboard" || loginState.Verifier == "" {
+		t.Errorf("unexpected login state %+v", loginState)
+	}
+
+	if _, err := ConsumeLoginState(state, "other-browser"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("state from another browser: err = %v, want %v", err, ErrInvalidState)
+	}
+	// A failed attempt uses the state up.
+	if _, err := ConsumeLoginState(state, binding); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("state reused after a failed attempt: err = %v", err)
+	}
+
+	state, loginState, binding, err = NewLoginState("/repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumed, err := ConsumeLoginState(state, binding)
+	if err != nil || consumed.Verifier != loginState.Verifier || consumed.ReturnTo != "/repos" {
+		t.Fatalf("ConsumeLoginState = %+v, %v", consumed, err)
+	}
+	if _, err := ConsumeLoginState(state, binding); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("state used twice: err = %v, want %v", err, ErrInvalidState)
+	}
+}
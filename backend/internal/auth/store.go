@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github-repo-manager/internal/secrets"
+	"github-repo-manager/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore persists the GitHub OAuth tokens of logged-in users.
+type TokenStore interface {
+	Save(token *UserToken) error
+	Get(userID int) (*UserToken, error)
+	Delete(userID int) error
+	List() ([]*UserToken, error)
+}
+
+var tokenStore TokenStore = NewMemoryTokenStore()
+
+// InitTokenStore selects the token store from TOKEN_STORE ("bolt" by
+// default, or "memory"). When master keys are configured, access tokens are
+// envelope-encrypted before they reach the store; the bolt store refuses to
+// run without them so tokens never sit on disk in plaintext.
+func InitTokenStore(db *bolt.DB) error {
+	keyring, err := secrets.LoadKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to load token encryption keys: %w", err)
+	}
+
+	var store TokenStore
+	switch backend := os.Getenv("TOKEN_STORE"); backend {
+	case "", "bolt":
+		if keyring == nil {
+			return fmt.Errorf("TOKEN_ENCRYPTION_KEYS or TOKEN_ENCRYPTION_KEYS_FILE must be set for the bolt token store")
+		}
+		if store, err = NewBoltTokenStore(db); err != nil {
+			return err
+		}
+	case "memory":
+		store = NewMemoryTokenStore()
+	default:
+		return fmt.Errorf("unknown TOKEN_STORE %q", backend)
+	}
+
+	if keyring != nil {
+		store = NewEncryptedTokenStore(store, keyring)
+	}
+	SetTokenStore(store)
+	return nil
+}
+
+// SetTokenStore replaces the token store, e.g. with a fake in tests.
+func SetTokenStore(store TokenStore) {
+	tokenStore = store
+}
+
+// MemoryTokenStore keeps tokens in process memory. Tokens are lost on restart.
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[int]*UserToken
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[int]*UserToken)}
+}
+
+func (s *MemoryTokenStore) Save(token *UserToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *token
+	s.tokens[token.UserID] = &stored
+	return nil
+}
+
+func (s *MemoryTokenStore) Get(userID int) (*UserToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[userID]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (s *MemoryTokenStore) Delete(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, userID)
+	return nil
+}
+
+func (s *MemoryTokenStore) List() ([]*UserToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]*UserToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		copied := *token
+		tokens = append(tokens, &copied)
+	}
+	return tokens, nil
+}
+
+var userTokensBucket = []byte("user_tokens")
+
+// BoltTokenStore keeps tokens in the embedded bbolt database so sessions
+// survive a backend restart.
+type BoltTokenStore struct {
+	db *bolt.DB
+}
+
+func NewBoltTokenStore(db *bolt.DB) (*BoltTokenStore, error) {
+	if err := storage.EnsureBuckets(db, userTokensBucket); err != nil {
+		return nil, fmt.Errorf("failed to initialize token store: %w", err)
+	}
+	return &BoltTokenStore{db: db}, nil
+}
+
+func (s *BoltTokenStore) Save(token *UserToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(userTokensBucket).Put(userKey(token.UserID), data)
+	})
+}
+
+func (s *BoltTokenStore) Get(userID int) (*UserToken, error) {
+	var token *UserToken
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(userTokensBucket).Get(userKey(userID))
+		if data == nil {
+			return ErrTokenNotFound
+		}
+		token = &UserToken{}
+		return json.Unmarshal(data, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *BoltTokenStore) Delete(userID int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(userTokensBucket).Delete(userKey(userID))
+	})
+}
+
+func (s *BoltTokenStore) List() ([]*UserToken, error) {
+	tokens := make([]*UserToken, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(userTokensBucket).ForEach(func(_, data []byte) error {
+			var token UserToken
+			if err := json.Unmarshal(data, &token); err != nil {
+				return fmt.Errorf("failed to decode token: %w", err)
+			}
+			tokens = append(tokens, &token)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func userKey(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
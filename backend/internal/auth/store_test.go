@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github-repo-manager/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func TestBoltTokenStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewBoltTokenStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []*UserToken{
+		{UserID: 1, Username: "octo", AccessToken: "gho_one", TokenType: "bearer"},
+		{UserID: 2, Username: "hubot", AccessToken: "gho_two", TokenType: "bearer"},
+	} {
+		if err := store.Save(token); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	if err := store.Delete(2); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// Tokens survive a restart.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = storage.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if store, err = NewBoltTokenStore(db); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if *token != (UserToken{UserID: 1, Username: "octo", AccessToken: "gho_one", TokenType: "bearer"}) {
+		t.Errorf("Get(1) = %+v", token)
+	}
+	if _, err := store.Get(2); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Get of a deleted token: err = %v, want %v", err, ErrTokenNotFound)
+	}
+	tokens, err := store.List()
+	if err != nil || len(tokens) != 1 || tokens[0].UserID != 1 {
+		t.Errorf("List() = %v, %v", tokens, err)
+	}
+}
+
+func TestSetTokenStore(t *testing.T) {
+	previous := tokenStore
+	defer SetTokenStore(previous)
+	store := NewMemoryTokenStore()
+	SetTokenStore(store)
+
+	if err := StoreUserToken(1, "octo", &oauth2.Token{AccessToken: "gho_one", TokenType: "bearer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := StoreUserToken(2, "hubot", &oauth2.Token{AccessToken: "gho_two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(1); err != nil {
+		t.Errorf("token wasn't saved in the replacement store: %v", err)
+	}
+	if token := GetOAuthToken(1); token == nil || token.AccessToken != "gho_one" || token.TokenType != "bearer" {
+		t.Errorf("GetOAuthToken(1) = %+v", token)
+	}
+	if token := GetOAuthToken(3); token != nil {
+		t.Errorf("GetOAuthToken of an unknown user = %+v", token)
+	}
+	userIDs, err := ListUserIDs()
+	sort.Ints(userIDs)
+	if err != nil || len(userIDs) != 2 || userIDs[0] != 1 || userIDs[1] != 2 {
+		t.Errorf("ListUserIDs() = %v, %v", userIDs, err)
+	}
+}
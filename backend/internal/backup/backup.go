@@ -0,0 +1,256 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github-repo-manager/internal/repository"
+)
+
+const defaultDirectory = "data/backups"
+
+// Archive is a backup written before a repository was deleted.
+type Archive struct {
+	Path      string    `json:"path"`
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Metadata is everything about a repository that a mirror clone doesn't
+// contain.
+type Metadata struct {
+	Repository json.RawMessage   `json:"repository"`
+	Topics     []string          `json:"topics"`
+	Labels     []json.RawMessage `json:"labels"`
+	Issues     []json.RawMessage `json:"issues"`
+	Releases   []json.RawMessage `json:"releases"`
+	ExportedAt time.Time         `json:"exported_at"`
+}
+
+// Service backs repositories up into a local archive directory.
+type Service struct {
+	dir string
+	git string
+}
+
+func New(dir string) *Service {
+	if dir == "" {
+		dir = defaultDirectory
+	}
+	return &Service{dir: dir, git: "git"}
+}
+
+// NewFromEnv returns the backup service configured by BACKUP_BEFORE_DELETE
+// and BACKUP_DIR, or nil when backups are disabled.
+func NewFromEnv() *Service {
+	if enabled, _ := strconv.ParseBool(os.Getenv("BACKUP_BEFORE_DELETE")); !enabled {
+		return nil
+	}
+	return New(os.Getenv("BACKUP_DIR"))
+}
+
+// Backup mirrors the repository's git data and exports its metadata into
+// <dir>/<owner>/<name>-<timestamp>.tar.gz, next to which it writes a
+// sha256sum compatible checksum file. Nothing is left behind on failure.
+func (s *Service) Backup(ctx context.Context, client *repository.GitHubClient, owner, name string) (*Archive, error) {
+	repo, err := client.GetRepository(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := os.MkdirTemp("", "repo-backup-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := s.mirror(ctx, repo.CloneURL, client.AccessToken(), filepath.Join(workDir, "repository.git")); err != nil {
+		return nil, err
+	}
+
+	metadata, err := exportMetadata(ctx, client, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "metadata.json"), data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	now := time.Now().UTC()
+	archivePath := filepath.Join(s.dir, safeName(owner), fmt.Sprintf("%s-%s.tar.gz", safeName(name), now.Format("20060102T150405Z")))
+	return writeArchive(workDir, archivePath, now)
+}
+
+// mirror runs git clone --mirror. The token is handed to git through the
+// environment so it never appears in the process list or the clone's config.
+func (s *Service) mirror(ctx context.Context, cloneURL, token, dest string) error {
+	if cloneURL == "" {
+		return fmt.Errorf("repository has no clone URL")
+	}
+
+	cmd := exec.CommandContext(ctx, s.git, "clone", "--mirror", "--quiet", "--", cloneURL, dest)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL=https:http:file")
+	if token != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials,
+		)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone --mirror failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func exportMetadata(ctx context.Context, client *repository.GitHubClient, owner, name string) (*Metadata, error) {
+	metadata := &Metadata{ExportedAt: time.Now().UTC()}
+	var err error
+	if metadata.Repository, err = client.GetRepositoryJSON(ctx, owner, name); err != nil {
+		return nil, err
+	}
+	if metadata.Topics, err = client.GetTopics(ctx, owner, name); err != nil {
+		return nil, err
+	}
+	if metadata.Labels, err = client.ListLabels(ctx, owner, name); err != nil {
+		return nil, err
+	}
+	if metadata.Issues, err = client.ListIssues(ctx, owner, name); err != nil {
+		return nil, err
+	}
+	if metadata.Releases, err = client.ListReleases(ctx, owner, name); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// writeArchive packs srcDir into a tar.gz at path, hashing it on the way.
+// The archive is written under a temporary name and only renamed into place
+// once complete.
+func writeArchive(srcDir, path string, createdAt time.Time) (archive *Archive, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, hash)}
+	gz := gzip.NewWriter(counter)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.WalkDir(srcDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err = tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err = gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	checksum := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	if err = os.WriteFile(path+".sha256", []byte(checksum), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write checksum: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(path + ".sha256")
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return &Archive{Path: path, SHA256: sum, Size: counter.n, CreatedAt: createdAt}, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// safeName keeps owner and repository names from escaping the backup
+// directory.
+func safeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github-repo-manager/internal/repository/githubtest"
+)
+
+// bareRemote creates a bare repository with one commit and returns its
+// path.
+func bareRemote(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	work := filepath.Join(dir, "work")
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "init.defaultBranch=main"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+		}
+	}
+	git("init", "--bare", "--quiet", remote)
+	git("init", "--quiet", work)
+	if err := os.WriteFile(filepath.Join(work, "README.md"), []byte("hello\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git("-C", work, "add", "README.md")
+	git("-C", work, "commit", "--quiet", "-m", "Initial commit")
+	git("-C", work, "push", "--quiet", remote, "HEAD:refs/heads/main")
+	return remote
+}
+
+// fakeAPI serves the repository and its metadata, with cloneURL as the
+// repository's clone URL.
+func fakeAPI(t *testing.T, cloneURL string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/repos/octo/demo":
+			fmt.Fprintf(w, `{"id":1,"name":"demo","full_name":"octo/demo","clone_url":%q,"owner":{"login":"octo"}}`, cloneURL)
+		case "/repos/octo/demo/topics":
+			fmt.Fprint(w, `{"names":["keep"]}`)
+		case "/repos/octo/demo/labels":
+			fmt.Fprint(w, `[{"name":"bug"}]`)
+		case "/repos/octo/demo/issues", "/repos/octo/demo/releases":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestBackup(t *testing.T) {
+	remote := bareRemote(t)
+	server := fakeAPI(t, "file://"+remote)
+	defer server.Close()
+	service := New(t.TempDir())
+
+	archive, err := service.Backup(context.Background(), githubtest.NewClient(t, server.URL), "octo", "demo")
+	if err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	data, err := os.ReadFile(archive.Path)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != archive.SHA256 || int64(len(data)) != archive.Size {
+		t.Errorf("archive checksum or size doesn't match %+v", archive)
+	}
+	checksum, err := os.ReadFile(archive.Path + ".sha256")
+	if err != nil || string(checksum) != archive.SHA256+"  "+filepath.Base(archive.Path)+"\n" {
+		t.Errorf("checksum file = %q, %v", checksum, err)
+	}
+
+	files := readArchive(t, archive.Path)
+	for _, name := range []string{"repository.git/HEAD", "repository.git/packed-refs"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive has no %s", name)
+		}
+	}
+	if !strings.Contains(files["repository.git/packed-refs"], "refs/heads/main") {
+		t.Errorf("mirror has no main branch: %q", files["repository.git/packed-refs"])
+	}
+
+	var metadata Metadata
+	if err := json.Unmarshal([]byte(files["metadata.json"]), &metadata); err != nil {
+		t.Fatalf("failed to decode metadata.json: %v", err)
+	}
+	var repo struct {
+		FullName string `json:"full_name"`
+	}
+	if err := json.Unmarshal(metadata.Repository, &repo); err != nil || repo.FullName != "octo/demo" {
+		t.Errorf("metadata repository = %s, %v", metadata.Repository, err)
+	}
+	if len(metadata.Topics) != 1 || metadata.Topics[0] != "keep" || len(metadata.Labels) != 1 {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+}
+
+func TestBackupCloneFailure(t *testing.T) {
+	server := fakeAPI(t, "file://"+filepath.Join(t.TempDir(), "missing.git"))
+	defer server.Close()
+	dir := t.TempDir()
+	service := New(dir)
+
+	archive, err := service.Backup(context.Background(), githubtest.NewClient(t, server.URL), "octo", "demo")
+	if err == nil {
+		t.Fatalf("Backup of an unreachable repository succeeded: %+v", archive)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "octo"))
+	if len(entries) != 0 {
+		t.Errorf("failed backup left %d files behind", len(entries))
+	}
+}
+
+// readArchive returns the regular files of a tar.gz by name.
+func readArchive(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(data)
+	}
+}
@@ -0,0 +1,168 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github-repo-manager/internal/repository"
+)
+
+const (
+	defaultParallelism = 4
+	defaultMinInterval = 250 * time.Millisecond
+	// pacerTTL is how long the pacer of a user without requests is kept.
+	pacerTTL = time.Hour
+)
+
+// Executor runs the items of a bulk operation with bounded concurrency.
+// GitHub rate limits are per token, so runs share a pacer per user: a user's
+// requests are spaced out and pause when GitHub reports a rate limit for
+// their token, without holding back anyone else's.
+type Executor struct {
+	parallelism int
+	minInterval time.Duration
+
+	mu     sync.Mutex
+	pacers map[int]*pacer
+}
+
+// pacer is the request schedule of one user.
+type pacer struct {
+	nextSlot    time.Time
+	pausedUntil time.Time
+}
+
+func NewExecutor(parallelism int, minInterval time.Duration) *Executor {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &Executor{parallelism: parallelism, minInterval: minInterval, pacers: make(map[int]*pacer)}
+}
+
+// NewExecutorFromEnv reads BULK_PARALLELISM and BULK_MIN_INTERVAL.
+func NewExecutorFromEnv() *Executor {
+	parallelism := defaultParallelism
+	if value, err := strconv.Atoi(os.Getenv("BULK_PARALLELISM")); err == nil && value > 0 {
+		parallelism = value
+	}
+	minInterval := defaultMinInterval
+	if value, err := time.ParseDuration(os.Getenv("BULK_MIN_INTERVAL")); err == nil && value >= 0 {
+		minInterval = value
+	}
+	return NewExecutor(parallelism, minInterval)
+}
+
+// Run calls fn once for every index in [0, count) on behalf of userID, using
+// at most parallelism goroutines, and returns the errors in input order.
+// Retrying is left to the GitHub client; an item failing with a rate limit
+// error it gave up on pauses the user's runs, so their other items don't run
+// into the same limit.
+func (e *Executor) Run(ctx context.Context, userID int, count int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, count)
+	indexes := make(chan int)
+
+	workers := e.parallelism
+	if workers > count {
+		workers = count
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = e.runItem(ctx, userID, i, fn)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
+
+func (e *Executor) runItem(ctx context.Context, userID, i int, fn func(ctx context.Context, i int) error) error {
+	if err := e.wait(ctx, userID); err != nil {
+		return err
+	}
+
+	err := fn(ctx, i)
+	if errors.Is(err, repository.ErrRateLimited) {
+		pause := repository.SecondaryRateLimitPause
+		var apiErr *repository.APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			pause = apiErr.RetryAfter
+		}
+		log.Printf("GitHub rate limit hit during bulk operation for user %d, pausing for %s", userID, pause)
+		e.pause(userID, pause)
+	}
+	return err
+}
+
+// wait blocks until this caller's turn: after any rate limit pause of the
+// user, and at least minInterval after their previous request started.
+func (e *Executor) wait(ctx context.Context, userID int) error {
+	e.mu.Lock()
+	p := e.pacerLocked(userID)
+	now := time.Now()
+	slot := now
+	if p.pausedUntil.After(slot) {
+		slot = p.pausedUntil
+	}
+	if p.nextSlot.After(slot) {
+		slot = p.nextSlot
+	}
+	p.nextSlot = slot.Add(e.minInterval)
+	e.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (e *Executor) pause(userID int, d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p := e.pacerLocked(userID)
+	if until := time.Now().Add(d); until.After(p.pausedUntil) {
+		p.pausedUntil = until
+	}
+}
+
+// pacerLocked returns the pacer of userID, creating it if needed. Pacers
+// idle for a while are dropped.
+func (e *Executor) pacerLocked(userID int) *pacer {
+	now := time.Now()
+	p, ok := e.pacers[userID]
+	if !ok {
+		for other, idle := range e.pacers {
+			if now.Sub(idle.nextSlot) > pacerTTL && now.After(idle.pausedUntil) {
+				delete(e.pacers, other)
+			}
+		}
+		p = &pacer{}
+		e.pacers[userID] = p
+	}
+	return p
+}
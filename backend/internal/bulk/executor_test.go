@@ -0,0 +1,108 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/repository"
+)
+
+func TestRunReturnsErrorsInInputOrder(t *testing.T) {
+	executor := NewExecutor(4, 0)
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	errs := executor.Run(context.Background(), 1, 20, func(ctx context.Context, i int) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		// Later items finish first.
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		if i%3 == 0 {
+			return fmt.Errorf("item %d failed", i)
+		}
+		return nil
+	})
+
+	if len(errs) != 20 {
+		t.Fatalf("got %d results, want 20", len(errs))
+	}
+	for i, err := range errs {
+		if i%3 == 0 {
+			if err == nil || err.Error() != fmt.Sprintf("item %d failed", i) {
+				t.Errorf("errs[%d] = %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("errs[%d] = %v, want nil", i, err)
+		}
+	}
+	if peak > 4 || peak < 2 {
+		t.Errorf("ran %d items at once, want 2 to 4", peak)
+	}
+}
+
+func TestRateLimitPausesOnlyThatUser(t *testing.T) {
+	executor := NewExecutor(2, 0)
+	limited := &repository.APIError{StatusCode: http.StatusForbidden, Kind: repository.ErrRateLimited, RetryAfter: time.Hour}
+
+	errs := executor.Run(context.Background(), 1, 1, func(ctx context.Context, i int) error {
+		return limited
+	})
+	if !errors.Is(errs[0], repository.ErrRateLimited) {
+		t.Fatalf("err = %v, want the rate limit error", errs[0])
+	}
+
+	// The limited user's next items wait for the pause to end.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	errs = executor.Run(ctx, 1, 2, func(ctx context.Context, i int) error {
+		calls++
+		return nil
+	})
+	if calls != 0 || !errors.Is(errs[0], context.DeadlineExceeded) || !errors.Is(errs[1], context.DeadlineExceeded) {
+		t.Errorf("paused user ran %d items, errs %v", calls, errs)
+	}
+
+	// Another user's token isn't limited.
+	start := time.Now()
+	errs = executor.Run(context.Background(), 2, 2, func(ctx context.Context, i int) error {
+		return nil
+	})
+	if errs[0] != nil || errs[1] != nil || time.Since(start) > time.Second {
+		t.Errorf("other user held back by the pause: %v after %s", errs, time.Since(start))
+	}
+}
+
+func TestRunSpacesRequestsPerUser(t *testing.T) {
+	executor := NewExecutor(4, 50*time.Millisecond)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for userID := 1; userID <= 2; userID++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			executor.Run(context.Background(), userID, 3, func(ctx context.Context, i int) error { return nil })
+		}(userID)
+	}
+	wg.Wait()
+
+	// Each user's three requests take two intervals; sharing a pacer would
+	// take five.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed >= 200*time.Millisecond {
+		t.Errorf("two users' runs took %s, want about 100ms", elapsed)
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// GitHubEndpoints are the URLs of the GitHub instance we talk to: github.com
+// by default, or a GitHub Enterprise Server when GITHUB_BASE_URL is set.
+type GitHubEndpoints struct {
+	WebURL   string
+	APIURL   string
+	AuthURL  string
+	TokenURL string
+	// UploadURL is where release assets are uploaded.
+	UploadURL string
+	// GraphQLURL is the GraphQL API endpoint, which on GHES is not under
+	// the REST API URL.
+	GraphQLURL string
+}
+
+// GitHub resolves the endpoints from the environment. GITHUB_BASE_URL is the
+// web root of a GHES instance (e.g. https://github.example.com); its API,
+// uploads and GraphQL URLs are derived from it unless GITHUB_API_URL /
+// GITHUB_UPLOADS_URL / GITHUB_GRAPHQL_URL override them.
+func GitHub() GitHubEndpoints {
+	endpoints := GitHubEndpoints{
+		WebURL:    "https://github.com",
+		APIURL:    "https://api.github.com",
+		UploadURL: "https://uploads.github.com",
+	}
+
+	if baseURL := trimURL(os.Getenv("GITHUB_BASE_URL")); baseURL != "" {
+		endpoints.WebURL = baseURL
+		endpoints.APIURL = baseURL + "/api/v3"
+		endpoints.UploadURL = baseURL + "/api/uploads"
+		endpoints.GraphQLURL = baseURL + "/api/graphql"
+	}
+	if apiURL := trimURL(os.Getenv("GITHUB_API_URL")); apiURL != "" {
+		endpoints.APIURL = apiURL
+	}
+	if uploadURL := trimURL(os.Getenv("GITHUB_UPLOADS_URL")); uploadURL != "" {
+		endpoints.UploadURL = uploadURL
+	}
+	if graphqlURL := trimURL(os.Getenv("GITHUB_GRAPHQL_URL")); graphqlURL != "" {
+		endpoints.GraphQLURL = graphqlURL
+	} else if endpoints.GraphQLURL == "" {
+		endpoints.GraphQLURL = endpoints.APIURL + "/graphql"
+	}
+
+	endpoints.AuthURL = endpoints.WebURL + "/login/oauth/authorize"
+	endpoints.TokenURL = endpoints.WebURL + "/login/oauth/access_token"
+	return endpoints
+}
+
+func trimURL(value string) string {
+	return strings.TrimRight(strings.TrimSpace(value), "/")
+}
+
+// Repository list backends.
+const (
+	ListBackendREST    = "rest"
+	ListBackendGraphQL = "graphql"
+)
+
+// RepositoryListBackend is the API repositories are listed with, set by
+// GITHUB_LIST_BACKEND. The GraphQL backend also lists language breakdowns,
+// default branch protection and last commit dates.
+func RepositoryListBackend() string {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("GITHUB_LIST_BACKEND")), ListBackendGraphQL) {
+		return ListBackendGraphQL
+	}
+	return ListBackendREST
+}
@@ -0,0 +1,75 @@
+package config
+
+import "testing"
+
+func TestGitHub(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want GitHubEndpoints
+	}{
+		{
+			name: "github.com",
+			want: GitHubEndpoints{
+				WebURL:     "https://github.com",
+				APIURL:     "https://api.github.com",
+				AuthURL:    "https://github.com/login/oauth/authorize",
+				TokenURL:   "https://github.com/login/oauth/access_token",
+				UploadURL:  "https://uploads.github.com",
+				GraphQLURL: "https://api.github.com/graphql",
+			},
+		},
+		{
+			name: "enterprise server",
+			env:  map[string]string{"GITHUB_BASE_URL": " https://github.example.com/ "},
+			want: GitHubEndpoints{
+				WebURL:     "https://github.example.com",
+				APIURL:     "https://github.example.com/api/v3",
+				AuthURL:    "https://github.example.com/login/oauth/authorize",
+				TokenURL:   "https://github.example.com/login/oauth/access_token",
+				UploadURL:  "https://github.example.com/api/uploads",
+				GraphQLURL: "https://github.example.com/api/graphql",
+			},
+		},
+		{
+			name: "overridden API",
+			env: map[string]string{
+				"GITHUB_BASE_URL":    "https://github.example.com",
+				"GITHUB_API_URL":     "https://api.example.com/",
+				"GITHUB_UPLOADS_URL": "https://uploads.example.com/",
+				"GITHUB_GRAPHQL_URL": "https://graphql.example.com",
+			},
+			want: GitHubEndpoints{
+				WebURL:     "https://github.example.com",
+				APIURL:     "https://api.example.com",
+				AuthURL:    "https://github.example.com/login/oauth/authorize",
+				TokenURL:   "https://github.example.com/login/oauth/access_token",
+				UploadURL:  "https://uploads.example.com",
+				GraphQLURL: "https://graphql.example.com",
+			},
+		},
+		{
+			name: "API only",
+			env:  map[string]string{"GITHUB_API_URL": "http://localhost:9000"},
+			want: GitHubEndpoints{
+				WebURL:     "https://github.com",
+				APIURL:     "http://localhost:9000",
+				AuthURL:    "https://github.com/login/oauth/authorize",
+				TokenURL:   "https://github.com/login/oauth/access_token",
+				UploadURL:  "https://uploads.github.com",
+				GraphQLURL: "http://localhost:9000/graphql",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"GITHUB_BASE_URL", "GITHUB_API_URL", "GITHUB_UPLOADS_URL", "GITHUB_GRAPHQL_URL"} {
+				t.Setenv(name, tt.env[name])
+			}
+			if got := GitHub(); got != tt.want {
+				t.Errorf("GitHub() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"time"
+
+	"github-repo-manager/internal/audit"
+	"github-repo-manager/internal/backup"
+	"github-repo-manager/internal/repository"
+)
+
+// Operations a job can perform on its repositories.
+const (
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Job statuses.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+)
+
+// Item statuses.
+const (
+	ItemPending   = "pending"
+	ItemRunning   = "running"
+	ItemSucceeded = "succeeded"
+	ItemFailed    = "failed"
+	ItemSkipped   = "skipped"
+)
+
+// Job is a persisted bulk operation over a list of repositories.
+type Job struct {
+	ID         string                       `json:"id"`
+	UserID     int                          `json:"user_id"`
+	Actor      audit.Actor                  `json:"actor"`
+	Operation  string                       `json:"operation"`
+	Updates    *repository.RepositoryUpdate `json:"updates,omitempty"`
+	Status     string                       `json:"status"`
+	Items      []Item                       `json:"items"`
+	CreatedAt  time.Time                    `json:"created_at"`
+	StartedAt  *time.Time                   `json:"started_at,omitempty"`
+	FinishedAt *time.Time                   `json:"finished_at,omitempty"`
+}
+
+// Item is the state of one repository of a job. RepositoryID, when set, is
+// the ID the repository had when the user confirmed the operation; the item
+// fails if it has changed since. Interrupted marks items that were running
+// when the backend stopped.
+type Item struct {
+	Owner        string                 `json:"owner"`
+	Name         string                 `json:"name"`
+	FullName     string                 `json:"full_name"`
+	RepositoryID int                    `json:"repository_id,omitempty"`
+	Status       string                 `json:"status"`
+	Error        string                 `json:"error,omitempty"`
+	Attempts     int                    `json:"attempts"`
+	Interrupted  bool                   `json:"interrupted,omitempty"`
+	Repository   *repository.Repository `json:"repository,omitempty"`
+	Backup       *backup.Archive        `json:"backup,omitempty"`
+	TrashID      string                 `json:"trash_id,omitempty"`
+}
+
+// Summary counts the items of a job by status.
+type Summary struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+}
+
+func (j *Job) Summary() Summary {
+	summary := Summary{Total: len(j.Items)}
+	for _, item := range j.Items {
+		switch item.Status {
+		case ItemPending:
+			summary.Pending++
+		case ItemRunning:
+			summary.Running++
+		case ItemSucceeded:
+			summary.Succeeded++
+		case ItemFailed:
+			summary.Failed++
+		case ItemSkipped:
+			summary.Skipped++
+		}
+	}
+	return summary
+}
+
+func (j *Job) Finished() bool {
+	return j.Status == StatusCompleted
+}
+
+// clone returns a deep enough copy of the job to hand out while workers keep
+// changing the original.
+func (j *Job) clone() *Job {
+	copied := *j
+	copied.Items = append([]Item(nil), j.Items...)
+	return &copied
+}
@@ -0,0 +1,531 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github-repo-manager/internal/audit"
+	"github-repo-manager/internal/backup"
+	"github-repo-manager/internal/bulk"
+	"github-repo-manager/internal/policy"
+	"github-repo-manager/internal/repocache"
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/trash"
+)
+
+var (
+	ErrInvalidJob   = errors.New("invalid job")
+	ErrNoClient     = errors.New("no GitHub token for user")
+	ErrBackupFailed = errors.New("backup failed, repository not deleted")
+
+	ErrRepositoryChanged = errors.New("repository is no longer the one that was confirmed")
+	// ErrNotAdmin means the user can see the repository, typically one of an
+	// organization, but isn't allowed to change its settings or delete it.
+	ErrNotAdmin = errors.New("admin permission required")
+)
+
+// Manager accepts jobs, runs them in the background on the shared bulk
+// executor and persists every state change.
+type Manager struct {
+	store    *Store
+	executor *bulk.Executor
+	cache    *repocache.Cache
+	clients  func(userID int) *repository.GitHubClient
+	backups  *backup.Service
+	trash    *trash.Service
+	policy   *policy.Policy
+	audit    *audit.Log
+
+	ctx context.Context
+
+	mu          sync.Mutex
+	running     map[string]*Job
+	done        map[string]chan struct{}
+	subscribers map[string]map[chan ItemEvent]struct{}
+}
+
+// ItemEvent reports that one item of a job has finished.
+type ItemEvent struct {
+	JobID   string  `json:"job_id"`
+	Index   int     `json:"index"`
+	Item    Item    `json:"item"`
+	Summary Summary `json:"summary"`
+}
+
+// Options are the dependencies of a Manager.
+type Options struct {
+	Executor *bulk.Executor
+	Cache    *repocache.Cache
+	Clients  func(userID int) *repository.GitHubClient
+	// Backups, if set, backs repositories up before they are deleted.
+	Backups *backup.Service
+	// Trash, if set, receives deleted repositories instead of GitHub's
+	// DELETE.
+	Trash *trash.Service
+	// Policy, if set, blocks changes to protected repositories.
+	Policy *policy.Policy
+	// Audit, if set, records every change attempted.
+	Audit *audit.Log
+}
+
+func NewManager(store *Store, opts Options) *Manager {
+	return &Manager{
+		store:    store,
+		executor: opts.Executor,
+		cache:    opts.Cache,
+		clients:  opts.Clients,
+		backups:  opts.Backups,
+		trash:    opts.Trash,
+		policy:   opts.Policy,
+		audit:    opts.Audit,
+		ctx:      context.Background(),
+		running:  make(map[string]*Job),
+		done:     make(map[string]chan struct{}),
+
+		subscribers: make(map[string]map[chan ItemEvent]struct{}),
+	}
+}
+
+// Start resumes the jobs that were still pending or running when the backend
+// stopped. Jobs run until ctx is cancelled.
+func (m *Manager) Start(ctx context.Context) error {
+	m.ctx = ctx
+
+	unfinished, err := m.store.List(func(job *Job) bool { return !job.Finished() })
+	if err != nil {
+		return fmt.Errorf("failed to load unfinished jobs: %w", err)
+	}
+	for _, job := range unfinished {
+		// Items interrupted mid-request are retried; see Execute for how a
+		// delete that already went through is recognized.
+		for i := range job.Items {
+			if job.Items[i].Status == ItemRunning {
+				job.Items[i].Status = ItemPending
+				job.Items[i].Interrupted = true
+			}
+		}
+		log.Printf("Resuming bulk job %s (%d items)", job.ID, job.Summary().Pending)
+		m.launch(job)
+	}
+	return nil
+}
+
+// Submit validates and persists a new job on behalf of actor, then starts
+// running it. Only Owner, Name and RepositoryID of repos are used.
+func (m *Manager) Submit(actor audit.Actor, operation string, updates *repository.RepositoryUpdate, repos []Item) (*Job, error) {
+	switch operation {
+	case OperationUpdate:
+		if updates == nil || updates.IsEmpty() {
+			return nil, fmt.Errorf("%w: no update data provided", ErrInvalidJob)
+		}
+	case OperationDelete:
+		updates = nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidJob, operation)
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("%w: no repositories specified", ErrInvalidJob)
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        id,
+		UserID:    actor.UserID,
+		Actor:     actor,
+		Operation: operation,
+		Updates:   updates,
+		Status:    StatusPending,
+		Items:     make([]Item, len(repos)),
+		CreatedAt: time.Now(),
+	}
+	for i, repo := range repos {
+		job.Items[i] = Item{
+			Owner:        repo.Owner,
+			Name:         repo.Name,
+			FullName:     repo.Owner + "/" + repo.Name,
+			RepositoryID: repo.RepositoryID,
+			Status:       ItemPending,
+		}
+	}
+
+	if err := m.store.Save(job); err != nil {
+		return nil, fmt.Errorf("failed to save job: %w", err)
+	}
+	submitted := job.clone()
+	m.launch(job)
+	return submitted, nil
+}
+
+// Get returns the current state of a job.
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	if job, ok := m.running[id]; ok {
+		defer m.mu.Unlock()
+		return job.clone(), nil
+	}
+	m.mu.Unlock()
+
+	return m.store.Get(id)
+}
+
+// Stopping reports whether the context passed to Start is done, i.e. the
+// backend is shutting down and running jobs are being checkpointed.
+func (m *Manager) Stopping() bool {
+	return m.ctx.Err() != nil
+}
+
+// Drain blocks until every running job has stopped or ctx is done. Called
+// after the context passed to Start is cancelled, it waits for the jobs to
+// save their progress so they can resume on the next start.
+func (m *Manager) Drain(ctx context.Context) error {
+	m.mu.Lock()
+	done := make([]chan struct{}, 0, len(m.done))
+	for _, ch := range m.done {
+		done = append(done, ch)
+	}
+	m.mu.Unlock()
+
+	for _, ch := range done {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// List returns the jobs of a user, newest first.
+func (m *Manager) List(userID int) ([]*Job, error) {
+	return m.store.List(func(job *Job) bool { return job.UserID == userID })
+}
+
+// Wait blocks until the job has finished or ctx is done, and returns the
+// job's latest state either way.
+func (m *Manager) Wait(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	done, ok := m.done[id]
+	m.mu.Unlock()
+
+	if ok {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	return m.Get(id)
+}
+
+// Subscribe returns the current state of a job together with a channel of
+// the item events that follow it, so nothing is missed or seen twice. The
+// channel is closed when the job stops running; it is nil if the job isn't
+// running. cancel must be called once the caller stops reading.
+func (m *Manager) Subscribe(id string) (job *Job, events <-chan ItemEvent, cancel func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	running, ok := m.running[id]
+	if !ok {
+		job, err := m.store.Get(id)
+		return job, nil, func() {}, err
+	}
+
+	// Every item finishes once per run, so a subscriber that keeps up never
+	// fills this; publish drops one that doesn't.
+	ch := make(chan ItemEvent, len(running.Items))
+	if m.subscribers[id] == nil {
+		m.subscribers[id] = make(map[chan ItemEvent]struct{})
+	}
+	m.subscribers[id][ch] = struct{}{}
+
+	cancel = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subscribers[id][ch]; ok {
+			delete(m.subscribers[id], ch)
+			close(ch)
+		}
+	}
+	return running.clone(), ch, cancel, nil
+}
+
+func (m *Manager) launch(job *Job) {
+	m.mu.Lock()
+	m.running[job.ID] = job
+	done := make(chan struct{})
+	m.done[job.ID] = done
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			delete(m.running, job.ID)
+			delete(m.done, job.ID)
+			for ch := range m.subscribers[job.ID] {
+				close(ch)
+			}
+			delete(m.subscribers, job.ID)
+			m.mu.Unlock()
+			close(done)
+		}()
+		m.run(job)
+	}()
+}
+
+func (m *Manager) run(job *Job) {
+	pending := make([]int, 0, len(job.Items))
+	for i, item := range job.Items {
+		if item.Status == ItemPending {
+			pending = append(pending, i)
+		}
+	}
+
+	m.update(job, func() {
+		now := time.Now()
+		job.Status = StatusRunning
+		if job.StartedAt == nil {
+			job.StartedAt = &now
+		}
+	})
+
+	client := m.clients(job.UserID)
+	m.executor.Run(m.ctx, job.UserID, len(pending), func(ctx context.Context, n int) error {
+		i := pending[n]
+		m.update(job, func() {
+			job.Items[i].Status = ItemRunning
+			job.Items[i].Attempts++
+		})
+
+		var result Result
+		err := ErrNoClient
+		if client != nil {
+			result, err = m.execute(ctx, client, job.Actor, job.ID, job.Operation, job.Updates, job.Items[i])
+		}
+		// An item cut short by shutdown hasn't finished: it stays running,
+		// to be retried as interrupted on the next start.
+		if err != nil && m.ctx.Err() != nil {
+			return err
+		}
+
+		m.update(job, func() {
+			item := &job.Items[i]
+			var protected *policy.ProtectedError
+			if errors.As(err, &protected) {
+				item.Status = ItemSkipped
+				item.Error = protected.Reason
+			} else if err != nil {
+				item.Status = ItemFailed
+				item.Error = errorMessage(err)
+			} else {
+				item.Status = ItemSucceeded
+				item.Error = ""
+				item.Repository = result.Repository
+			}
+			if result.Backup != nil {
+				item.Backup = result.Backup
+			}
+			if result.TrashID != "" {
+				item.TrashID = result.TrashID
+			}
+			m.publish(ItemEvent{JobID: job.ID, Index: i, Item: *item, Summary: job.Summary()})
+		})
+		return err
+	})
+
+	// Cancellation (shutdown) leaves the job unfinished so it resumes on the
+	// next start.
+	if m.ctx.Err() != nil {
+		return
+	}
+
+	m.update(job, func() {
+		now := time.Now()
+		job.Status = StatusCompleted
+		job.FinishedAt = &now
+	})
+	summary := job.Summary()
+	log.Printf("Bulk job %s (%s) for user %d completed: %d succeeded, %d failed, %d skipped",
+		job.ID, job.Operation, job.UserID, summary.Succeeded, summary.Failed, summary.Skipped)
+}
+
+// Result is what performing an operation on one repository produced.
+type Result struct {
+	Repository *repository.Repository
+	Backup     *backup.Archive
+	TrashID    string
+}
+
+// Execute performs an operation on one repository on behalf of actor: the
+// same path job items take, for endpoints that change a single repository
+// synchronously.
+func (m *Manager) Execute(ctx context.Context, client *repository.GitHubClient, actor audit.Actor, operation string, updates *repository.RepositoryUpdate, item Item) (Result, error) {
+	return m.execute(ctx, client, actor, "", operation, updates, item)
+}
+
+func (m *Manager) execute(ctx context.Context, client *repository.GitHubClient, actor audit.Actor, jobID, operation string, updates *repository.RepositoryUpdate, item Item) (Result, error) {
+	entry := &audit.Entry{
+		Actor:        actor,
+		Action:       operation,
+		JobID:        jobID,
+		RepositoryID: item.RepositoryID,
+		FullName:     item.FullName,
+	}
+	result, err := m.perform(ctx, client, operation, updates, item, entry)
+	m.audit.Record(entry, err)
+	return result, err
+}
+
+// perform does the work of execute, filling in the audit entry as it goes.
+func (m *Manager) perform(ctx context.Context, client *repository.GitHubClient, operation string, updates *repository.RepositoryUpdate, item Item, entry *audit.Entry) (Result, error) {
+	var result Result
+	switch operation {
+	case OperationUpdate:
+		entry.Action = audit.ActionUpdate
+		if updates == nil || updates.IsEmpty() {
+			return result, fmt.Errorf("%w: no update data provided", ErrInvalidJob)
+		}
+		if err := m.preflight(ctx, client, item, entry); err != nil {
+			return result, err
+		}
+		updated, err := client.UpdateRepository(ctx, item.Owner, item.Name, *updates)
+		if err != nil {
+			return result, err
+		}
+		entry.After = audit.RepositoryState(updated)
+		entry.GitHubStatus = http.StatusOK
+		if err := m.cache.Put(entry.UserID, *updated); err != nil {
+			log.Printf("Failed to update repository cache for user %d: %v", entry.UserID, err)
+		}
+		result.Repository = updated
+		return result, nil
+	case OperationDelete:
+		entry.Action = audit.ActionDelete
+		if m.trash != nil {
+			entry.Action = audit.ActionTrash
+		}
+		err := m.preflight(ctx, client, item, entry)
+		// A retried delete finding nothing means the interrupted attempt
+		// already deleted the repository.
+		if item.Interrupted && errors.Is(err, repository.ErrNotFound) {
+			m.removeFromCache(entry.UserID, item.FullName)
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if m.trash != nil {
+			trashEntry, repo, err := m.trash.Trash(ctx, client, entry.UserID, item.Owner, item.Name)
+			if trashEntry != nil {
+				result.TrashID = trashEntry.ID
+			}
+			if err != nil {
+				return result, fmt.Errorf("failed to move repository to the trash: %w", err)
+			}
+			entry.After = audit.RepositoryState(repo)
+			entry.GitHubStatus = http.StatusOK
+			if err := m.cache.Put(entry.UserID, *repo); err != nil {
+				log.Printf("Failed to update repository cache for user %d: %v", entry.UserID, err)
+			}
+			result.Repository = repo
+			return result, nil
+		}
+
+		if m.backups != nil && item.Backup == nil {
+			archive, err := m.backups.Backup(ctx, client, item.Owner, item.Name)
+			if err != nil {
+				return result, fmt.Errorf("%w: %w", ErrBackupFailed, err)
+			}
+			result.Backup = archive
+			log.Printf("Backed up %s to %s before deleting it", item.FullName, archive.Path)
+		}
+
+		if err := client.DeleteRepository(ctx, item.Owner, item.Name); err != nil {
+			return result, err
+		}
+		entry.GitHubStatus = http.StatusNoContent
+		m.removeFromCache(entry.UserID, item.FullName)
+		return result, nil
+	}
+	return result, fmt.Errorf("%w: unknown operation %q", ErrInvalidJob, operation)
+}
+
+func (m *Manager) removeFromCache(userID int, fullName string) {
+	if err := m.cache.Remove(userID, fullName); err != nil {
+		log.Printf("Failed to update repository cache for user %d: %v", userID, err)
+	}
+}
+
+// preflight checks the repository before it is changed: that it is still
+// the one the user confirmed, and not e.g. a new repository created under a
+// renamed one's old name, that the user is an admin of it and that the
+// protection policy allows it. It records the repository's current state in
+// the audit entry.
+func (m *Manager) preflight(ctx context.Context, client *repository.GitHubClient, item Item, entry *audit.Entry) error {
+	repo, err := client.GetRepository(ctx, item.Owner, item.Name)
+	if err != nil {
+		return err
+	}
+	entry.RepositoryID = repo.ID
+	entry.Before = audit.RepositoryState(repo)
+	if item.RepositoryID != 0 && repo.ID != item.RepositoryID {
+		return fmt.Errorf("%w: %s", ErrRepositoryChanged, item.FullName)
+	}
+	if !repo.CanAdminister() {
+		return fmt.Errorf("%w on %s", ErrNotAdmin, item.FullName)
+	}
+	return m.policy.Check(repo)
+}
+
+// update applies change to a running job and persists the result.
+func (m *Manager) update(job *Job, change func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	change()
+	if err := m.store.Save(job); err != nil {
+		log.Printf("Failed to save bulk job %s: %v", job.ID, err)
+	}
+}
+
+// publish sends an event to the job's subscribers. It never blocks, as
+// callers hold m.mu: a subscriber whose buffer is full is dropped and its
+// channel closed, ending its stream early rather than stalling the job.
+func (m *Manager) publish(event ItemEvent) {
+	for ch := range m.subscribers[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropping slow subscriber of bulk job %s", event.JobID)
+			delete(m.subscribers[event.JobID], ch)
+			close(ch)
+		}
+	}
+}
+
+func errorMessage(err error) string {
+	var apiErr *repository.APIError
+	if errors.As(err, &apiErr) && apiErr.Message != "" {
+		return apiErr.Message
+	}
+	return err.Error()
+}
+
+func newJobID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,264 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/audit"
+	"github-repo-manager/internal/backup"
+	"github-repo-manager/internal/bulk"
+	"github-repo-manager/internal/repocache"
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/repository/githubtest"
+	"github-repo-manager/internal/storage"
+)
+
+// newTestManager returns a manager with its own database, and a repository
+// cache unless opts has one.
+func newTestManager(t *testing.T, opts Options) (*Manager, *Store) {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store, err := NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Cache == nil {
+		if opts.Cache, err = repocache.New(db, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewManager(store, opts), store
+}
+
+func TestDeleteStopsWhenBackupFails(t *testing.T) {
+	deletes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes++
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.URL.Path != "/repos/octo/demo" {
+			http.NotFound(w, r)
+			return
+		}
+		// The clone URL points at a remote that doesn't exist.
+		fmt.Fprintf(w, `{"id":1,"name":"demo","full_name":"octo/demo","clone_url":%q,"owner":{"login":"octo"},"permissions":{"admin":true}}`,
+			"file://"+filepath.Join(t.TempDir(), "missing.git"))
+	}))
+	defer server.Close()
+
+	client := githubtest.NewClient(t, server.URL)
+	manager, _ := newTestManager(t, Options{Backups: backup.New(t.TempDir())})
+
+	item := Item{Owner: "octo", Name: "demo", FullName: "octo/demo", RepositoryID: 1}
+	_, err := manager.Execute(context.Background(), client, audit.Actor{UserID: 1, Username: "octo"}, OperationDelete, nil, item)
+	if !errors.Is(err, ErrBackupFailed) {
+		t.Fatalf("err = %v, want %v", err, ErrBackupFailed)
+	}
+	if deletes != 0 {
+		t.Errorf("repository deleted %d times although its backup failed", deletes)
+	}
+}
+
+func TestShutdownCheckpointsRunningJobs(t *testing.T) {
+	patched := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			// Hang until the test is over.
+			close(patched)
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		fmt.Fprint(w, `{"id":1,"name":"demo","full_name":"octo/demo","owner":{"login":"octo"},"permissions":{"admin":true}}`)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := githubtest.NewClient(t, server.URL)
+	manager, store := newTestManager(t, Options{
+		Executor: bulk.NewExecutor(1, 0),
+		Clients:  func(int) *repository.GitHubClient { return client },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := manager.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	private := true
+	job, err := manager.Submit(audit.Actor{UserID: 1, Username: "octo"}, OperationUpdate,
+		&repository.RepositoryUpdate{Private: &private}, []Item{{Owner: "octo", Name: "demo", RepositoryID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, events, unsubscribe, err := manager.Subscribe(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	select {
+	case <-patched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job never sent its update")
+	}
+	if manager.Stopping() {
+		t.Error("Stopping before shutdown")
+	}
+	cancel()
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer drainCancel()
+	if err := manager.Drain(drainCtx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if !manager.Stopping() {
+		t.Error("not Stopping after shutdown")
+	}
+	if _, ok := <-events; ok {
+		t.Error("got an event for an item cut short by shutdown")
+	}
+
+	saved, err := store.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Finished() || saved.Items[0].Status != ItemRunning {
+		t.Errorf("job saved as %s with item %s, want it left running to resume", saved.Status, saved.Items[0].Status)
+	}
+}
+
+func TestSubscribersGetItemEventsThenTheEnd(t *testing.T) {
+	start := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			<-start
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/repos/octo/")
+		fmt.Fprintf(w, `{"id":1,"name":%q,"full_name":"octo/%s","owner":{"login":"octo"},"permissions":{"admin":true}}`, name, name)
+	}))
+	defer server.Close()
+
+	client := githubtest.NewClient(t, server.URL)
+	manager, _ := newTestManager(t, Options{
+		Executor: bulk.NewExecutor(1, 0),
+		Clients:  func(int) *repository.GitHubClient { return client },
+	})
+
+	private := true
+	items := []Item{{Owner: "octo", Name: "one"}, {Owner: "octo", Name: "two"}, {Owner: "octo", Name: "three"}}
+	job, err := manager.Submit(audit.Actor{UserID: 1, Username: "octo"}, OperationUpdate, &repository.RepositoryUpdate{Private: &private}, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, events, unsubscribe, err := manager.Subscribe(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	// This one never reads; the job must not wait for it.
+	_, _, unsubscribeIdle, err := manager.Subscribe(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribeIdle()
+	close(start)
+
+	var received []ItemEvent
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				done = true
+				break
+			}
+			received = append(received, event)
+		case <-timeout:
+			t.Fatal("the event stream never ended")
+		}
+	}
+
+	if len(received) != len(items) {
+		t.Fatalf("got %d events, want %d", len(received), len(items))
+	}
+	for n, event := range received {
+		if event.JobID != job.ID || event.Index != n || event.Item.Status != ItemSucceeded || event.Summary.Succeeded != n+1 {
+			t.Errorf("event %d = %+v", n, event)
+		}
+	}
+	if last := received[len(received)-1].Summary; last != (Summary{Total: 3, Succeeded: 3}) {
+		t.Errorf("final summary = %+v", last)
+	}
+
+	finished, err := manager.Wait(context.Background(), job.ID)
+	if err != nil || finished.Status != StatusCompleted || finished.Summary() != received[len(received)-1].Summary {
+		t.Errorf("finished job = %+v, %v", finished, err)
+	}
+	// The job is over: subscribing returns it without a stream.
+	if job, events, _, err := manager.Subscribe(job.ID); err != nil || events != nil || job.Status != StatusCompleted {
+		t.Errorf("Subscribe after the job = %+v, %v, %v", job, events, err)
+	}
+}
+
+func TestPublishDropsFullSubscribers(t *testing.T) {
+	manager, _ := newTestManager(t, Options{})
+	manager.running["job"] = &Job{ID: "job", Items: make([]Item, 1)}
+
+	_, slow, _, err := manager.Subscribe("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fast, _, err := manager.Subscribe("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for index := 0; index < 2; index++ {
+			manager.mu.Lock()
+			manager.publish(ItemEvent{JobID: "job", Index: index})
+			manager.mu.Unlock()
+			if index == 0 {
+				<-fast
+			}
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a full subscriber")
+	}
+
+	// The slow subscriber keeps what fit and is then cut off.
+	if event, ok := <-slow; !ok || event.Index != 0 {
+		t.Errorf("slow subscriber got %+v, %t", event, ok)
+	}
+	if _, ok := <-slow; ok {
+		t.Error("slow subscriber was not dropped")
+	}
+	if event, ok := <-fast; !ok || event.Index != 1 {
+		t.Errorf("fast subscriber got %+v, %t", event, ok)
+	}
+	if n := len(manager.subscribers["job"]); n != 1 {
+		t.Errorf("%d subscribers left, want 1", n)
+	}
+}
@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github-repo-manager/internal/policy"
+	"github-repo-manager/internal/repository"
+)
+
+// Plan actions.
+const (
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionNone   = "none"
+	ActionSkip   = "skip"
+)
+
+// recentPushWindow is how recent a push has to be to warn before deleting.
+const recentPushWindow = 30 * 24 * time.Hour
+
+// PlanItem describes what a job would do to one repository, based on its
+// current state on GitHub.
+type PlanItem struct {
+	Owner      string                 `json:"owner"`
+	Name       string                 `json:"name"`
+	FullName   string                 `json:"full_name"`
+	Action     string                 `json:"action"`
+	Changes    []Change               `json:"changes"`
+	Summary    string                 `json:"summary"`
+	Warnings   []string               `json:"warnings"`
+	Error      string                 `json:"error,omitempty"`
+	Repository *repository.Repository `json:"repository,omitempty"`
+}
+
+// Change is one setting a job would change.
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Field, c.From, c.To)
+}
+
+// Plan fetches the current state of every repository and describes what
+// running the operation would do, without changing anything.
+func (m *Manager) Plan(ctx context.Context, userID int, operation string, updates *repository.RepositoryUpdate, repos []Item) ([]PlanItem, error) {
+	client := m.clients(userID)
+	if client == nil {
+		return nil, ErrNoClient
+	}
+	if operation == OperationUpdate && (updates == nil || updates.IsEmpty()) {
+		return nil, fmt.Errorf("%w: no update data provided", ErrInvalidJob)
+	}
+	if operation != OperationUpdate && operation != OperationDelete {
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidJob, operation)
+	}
+
+	now := time.Now()
+	plan := make([]PlanItem, len(repos))
+	m.executor.Run(ctx, userID, len(repos), func(ctx context.Context, i int) error {
+		ref := repos[i]
+		item := PlanItem{
+			Owner:    ref.Owner,
+			Name:     ref.Name,
+			FullName: ref.Owner + "/" + ref.Name,
+			Action:   ActionNone,
+			Changes:  make([]Change, 0),
+			Warnings: make([]string, 0),
+		}
+		defer func() { plan[i] = item }()
+
+		repo, err := client.GetRepository(ctx, ref.Owner, ref.Name)
+		if err != nil {
+			item.Error = errorMessage(err)
+			if errors.Is(err, repository.ErrNotFound) {
+				item.Summary = "repository not found or not accessible"
+			}
+			// A rate limit pauses the user's remaining items.
+			return err
+		}
+		item.Repository = repo
+
+		if !repo.CanAdminister() {
+			item.Error = fmt.Sprintf("%v on %s", ErrNotAdmin, item.FullName)
+			item.Summary = "you need admin permission on this repository to change or delete it"
+			return nil
+		}
+
+		var protected *policy.ProtectedError
+		if errors.As(m.policy.Check(repo), &protected) {
+			item.Action = ActionSkip
+			item.Summary = "skipped: " + protected.Reason
+			return nil
+		}
+
+		if operation == OperationDelete {
+			planDelete(&item, repo, now)
+		} else {
+			planUpdate(&item, repo, *updates)
+		}
+		return nil
+	})
+	return plan, nil
+}
+
+func planUpdate(item *PlanItem, repo *repository.Repository, updates repository.RepositoryUpdate) {
+	if updates.Private != nil && *updates.Private != repo.Private {
+		item.Changes = append(item.Changes, Change{Field: "private", From: repo.Private, To: *updates.Private})
+		if !*updates.Private {
+			item.Warnings = append(item.Warnings, "repository will become publicly visible")
+		}
+	}
+	if updates.Archived != nil && *updates.Archived != repo.Archived {
+		item.Changes = append(item.Changes, Change{Field: "archived", From: repo.Archived, To: *updates.Archived})
+		if *updates.Archived {
+			item.Warnings = append(item.Warnings, "repository will become read-only")
+		}
+	}
+	if repo.Archived && updates.Private != nil && (updates.Archived == nil || *updates.Archived) {
+		item.Warnings = append(item.Warnings, "repository is archived; its visibility can't be changed until it is unarchived")
+	}
+
+	if len(item.Changes) == 0 {
+		item.Summary = "no changes"
+		return
+	}
+	item.Action = ActionUpdate
+	descriptions := make([]string, len(item.Changes))
+	for i, change := range item.Changes {
+		descriptions[i] = change.String()
+	}
+	item.Summary = strings.Join(descriptions, ", ")
+}
+
+func planDelete(item *PlanItem, repo *repository.Repository, now time.Time) {
+	item.Action = ActionDelete
+
+	parts := []string{
+		"will be deleted",
+		plural(repo.StargazersCount, "star"),
+		plural(repo.ForksCount, "fork"),
+	}
+	pushedAt, err := time.Parse(time.RFC3339, repo.PushedAt)
+	if err == nil {
+		parts = append(parts, "last push "+humanizeAge(now.Sub(pushedAt)))
+	}
+	item.Summary = strings.Join(parts, ", ")
+
+	if !repo.Private {
+		item.Warnings = append(item.Warnings, "repository is public")
+	}
+	if repo.StargazersCount > 0 {
+		item.Warnings = append(item.Warnings, fmt.Sprintf("%s will be lost", plural(repo.StargazersCount, "star")))
+	}
+	if repo.ForksCount > 0 {
+		// Forks of a private repository are deleted with it; forks of a
+		// public one are detached.
+		if repo.Private {
+			item.Warnings = append(item.Warnings, fmt.Sprintf("%s will also be deleted", plural(repo.ForksCount, "fork")))
+		} else {
+			item.Warnings = append(item.Warnings, fmt.Sprintf("%s will be detached from this repository", plural(repo.ForksCount, "fork")))
+		}
+	}
+	if repo.OpenIssuesCount > 0 {
+		item.Warnings = append(item.Warnings, fmt.Sprintf("%s will be lost", plural(repo.OpenIssuesCount, "open issue")))
+	}
+	if err == nil && now.Sub(pushedAt) < recentPushWindow {
+		item.Warnings = append(item.Warnings, "repository was pushed to recently")
+	}
+	if !repo.Archived {
+		item.Warnings = append(item.Warnings, "repository is not archived")
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// humanizeAge formats a duration like "3 hours ago" or "2 days ago".
+func humanizeAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return plural(int(age/time.Minute), "minute") + " ago"
+	case age < 24*time.Hour:
+		return plural(int(age/time.Hour), "hour") + " ago"
+	case age < 365*24*time.Hour:
+		return plural(int(age/(24*time.Hour)), "day") + " ago"
+	}
+	return plural(int(age/(365*24*time.Hour)), "year") + " ago"
+}
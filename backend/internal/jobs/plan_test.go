@@ -0,0 +1,160 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/bulk"
+	"github-repo-manager/internal/policy"
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/repository/githubtest"
+)
+
+// planServer serves the repositories planned against and counts any request
+// that would change something.
+func planServer(t *testing.T) (*repository.GitHubClient, func() int) {
+	t.Helper()
+	recent := time.Now().Add(-48 * time.Hour).Format(time.RFC3339)
+	old := time.Now().AddDate(-2, 0, 0).Format(time.RFC3339)
+	repos := map[string]string{
+		"/repos/octo/public":   fmt.Sprintf(`{"id":1,"name":"public","full_name":"octo/public","private":false,"stargazers_count":2,"forks_count":1,"open_issues_count":3,"pushed_at":%q,"owner":{"login":"octo"},"permissions":{"admin":true}}`, recent),
+		"/repos/octo/archived": fmt.Sprintf(`{"id":2,"name":"archived","full_name":"octo/archived","private":true,"archived":true,"stargazers_count":1,"forks_count":2,"pushed_at":%q,"owner":{"login":"octo"},"permissions":{"admin":true}}`, old),
+		"/repos/octo/readonly": `{"id":3,"name":"readonly","full_name":"octo/readonly","owner":{"login":"octo"},"permissions":{"admin":false,"push":true}}`,
+		"/repos/octo/keep":     `{"id":4,"name":"keep","full_name":"octo/keep","owner":{"login":"octo"},"permissions":{"admin":true}}`,
+	}
+
+	var mu sync.Mutex
+	mutations := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			mu.Lock()
+			mutations++
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		repo, ok := repos[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"Not Found"}`)
+			return
+		}
+		fmt.Fprint(w, repo)
+	}))
+	t.Cleanup(server.Close)
+
+	return githubtest.NewClient(t, server.URL), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return mutations
+	}
+}
+
+func newPlanManager(t *testing.T, client *repository.GitHubClient) *Manager {
+	t.Helper()
+	manager, _ := newTestManager(t, Options{
+		Executor: bulk.NewExecutor(2, 0),
+		Clients:  func(int) *repository.GitHubClient { return client },
+		Policy:   &policy.Policy{Patterns: []string{"octo/keep"}},
+	})
+	return manager
+}
+
+var planItems = []Item{
+	{Owner: "octo", Name: "public"},
+	{Owner: "octo", Name: "archived"},
+	{Owner: "octo", Name: "readonly"},
+	{Owner: "octo", Name: "keep"},
+	{Owner: "octo", Name: "missing"},
+}
+
+func TestPlanDelete(t *testing.T) {
+	client, mutations := planServer(t)
+	manager := newPlanManager(t, client)
+
+	plan, err := manager.Plan(context.Background(), 1, OperationDelete, nil, planItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mutations() != 0 {
+		t.Errorf("planning sent %d changing requests to GitHub", mutations())
+	}
+
+	public := plan[0]
+	if public.Action != ActionDelete || public.Summary != "will be deleted, 2 stars, 1 fork, last push 2 days ago" {
+		t.Errorf("public plan = %+v", public)
+	}
+	wantWarnings := []string{
+		"repository is public",
+		"2 stars will be lost",
+		"1 fork will be detached from this repository",
+		"3 open issues will be lost",
+		"repository was pushed to recently",
+		"repository is not archived",
+	}
+	if !reflect.DeepEqual(public.Warnings, wantWarnings) {
+		t.Errorf("public warnings = %q, want %q", public.Warnings, wantWarnings)
+	}
+
+	archived := plan[1]
+	wantWarnings = []string{"1 star will be lost", "2 forks will also be deleted"}
+	if archived.Action != ActionDelete || archived.Summary != "will be deleted, 1 star, 2 forks, last push 2 years ago" || !reflect.DeepEqual(archived.Warnings, wantWarnings) {
+		t.Errorf("archived plan = %+v", archived)
+	}
+
+	if readonly := plan[2]; readonly.Action != ActionNone || readonly.Error == "" || readonly.Repository == nil {
+		t.Errorf("read-only plan = %+v", readonly)
+	}
+	if keep := plan[3]; keep.Action != ActionSkip || keep.Summary != `skipped: matches protected pattern "octo/keep"` {
+		t.Errorf("protected plan = %+v", keep)
+	}
+	if missing := plan[4]; missing.Action != ActionNone || missing.Error == "" || missing.Summary != "repository not found or not accessible" {
+		t.Errorf("missing plan = %+v", missing)
+	}
+}
+
+func TestPlanUpdate(t *testing.T) {
+	client, mutations := planServer(t)
+	manager := newPlanManager(t, client)
+
+	private, archived := false, false
+	plan, err := manager.Plan(context.Background(), 1, OperationUpdate, &repository.RepositoryUpdate{Private: &private}, planItems[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public := plan[0]; public.Action != ActionNone || public.Summary != "no changes" || len(public.Changes) != 0 {
+		t.Errorf("public plan = %+v", public)
+	}
+	wantChanges := []Change{{Field: "private", From: true, To: false}}
+	wantWarnings := []string{
+		"repository will become publicly visible",
+		"repository is archived; its visibility can't be changed until it is unarchived",
+	}
+	if item := plan[1]; item.Action != ActionUpdate || item.Summary != "private: true -> false" ||
+		!reflect.DeepEqual(item.Changes, wantChanges) || !reflect.DeepEqual(item.Warnings, wantWarnings) {
+		t.Errorf("archived plan = %+v", item)
+	}
+
+	// Unarchiving in the same request lifts the visibility warning.
+	plan, err = manager.Plan(context.Background(), 1, OperationUpdate, &repository.RepositoryUpdate{Private: &private, Archived: &archived}, planItems[1:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantChanges = append(wantChanges, Change{Field: "archived", From: true, To: false})
+	if item := plan[0]; !reflect.DeepEqual(item.Changes, wantChanges) || !reflect.DeepEqual(item.Warnings, wantWarnings[:1]) {
+		t.Errorf("unarchive plan = %+v", item)
+	}
+	if mutations() != 0 {
+		t.Errorf("planning sent %d changing requests to GitHub", mutations())
+	}
+
+	if _, err := manager.Plan(context.Background(), 1, OperationUpdate, &repository.RepositoryUpdate{}, planItems); err == nil {
+		t.Error("Plan of an empty update succeeded")
+	}
+}
@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github-repo-manager/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+var jobsBucket = []byte("bulk_jobs")
+
+// Store persists jobs in the embedded database so they survive a restart.
+type Store struct {
+	db *bolt.DB
+}
+
+func NewStore(db *bolt.DB) (*Store, error) {
+	if err := storage.EnsureBuckets(db, jobsBucket); err != nil {
+		return nil, fmt.Errorf("failed to initialize job store: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (s *Store) Get(id string) (*Job, error) {
+	var job *Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrJobNotFound
+		}
+		job = &Job{}
+		return json.Unmarshal(data, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// List returns the jobs accepted by keep, newest first.
+func (s *Store) List(keep func(job *Job) bool) ([]*Job, error) {
+	jobs := make([]*Job, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(data, job); err != nil {
+				return err
+			}
+			if keep(job) {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
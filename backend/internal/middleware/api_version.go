@@ -0,0 +1,14 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+const apiVersionHeader = "X-API-Version"
+
+// APIVersion tells clients which version of the response representations
+// they are getting in the X-API-Version header.
+func APIVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(apiVersionHeader, version)
+		c.Next()
+	}
+}
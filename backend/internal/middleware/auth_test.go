@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github-repo-manager/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthMiddlewareRejectsRevokedSession(t *testing.T) {
+	t.Setenv("TOKEN_STORE", "memory")
+	if err := auth.InitSigningKeys(nil); err != nil {
+		t.Fatal(err)
+	}
+	auth.SetSessionStore(auth.NewMemorySessionStore())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/me", AuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetInt("user_id")})
+	})
+	get := func(header string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	accessToken, refreshToken, err := auth.CreateSession(1, "octo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := get("Bearer " + accessToken); code != http.StatusOK {
+		t.Fatalf("valid token: status %d, want 200", code)
+	}
+	for _, header := range []string{"", accessToken, "Bearer", "Bearer not-a-jwt"} {
+		if code := get(header); code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want 401", header, code)
+		}
+	}
+
+	// Replaying a rotated refresh token revokes the session, and with it
+	// every access token it issued.
+	if _, _, err := auth.RefreshSession(refreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auth.RefreshSession(refreshToken); err == nil {
+		t.Fatal("replayed refresh token was accepted")
+	}
+	if code := get("Bearer " + accessToken); code != http.StatusUnauthorized {
+		t.Errorf("token of a revoked session: status %d, want 401", code)
+	}
+}
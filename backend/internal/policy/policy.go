@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github-repo-manager/internal/repository"
+)
+
+var ErrProtected = errors.New("repository is protected")
+
+// ProtectedError reports why the policy blocked a repository. It unwraps to
+// ErrProtected.
+type ProtectedError struct {
+	Reason string
+}
+
+func (e *ProtectedError) Error() string {
+	return "repository is protected: " + e.Reason
+}
+
+func (e *ProtectedError) Unwrap() error {
+	return ErrProtected
+}
+
+// Policy decides which repositories must not be updated or deleted. A
+// repository is protected if any rule matches it.
+type Policy struct {
+	// Patterns are globs matched against full_name, e.g. "acme/*" or
+	// "*/prod-*". Matching is case insensitive.
+	Patterns []string
+	Topics   []string
+	// MinStars and MinForks protect repositories with at least that many
+	// stars or forks; zero disables the rule.
+	MinStars int
+	MinForks int
+	// RecentPush protects repositories pushed to within this long.
+	RecentPush time.Duration
+}
+
+// FromEnv reads the policy from PROTECTED_REPOSITORIES, PROTECTED_TOPICS
+// (both comma separated), PROTECTED_MIN_STARS, PROTECTED_MIN_FORKS and
+// PROTECTED_RECENT_PUSH. It returns nil if none of them is set.
+func FromEnv() (*Policy, error) {
+	p := &Policy{
+		Patterns: splitList(os.Getenv("PROTECTED_REPOSITORIES")),
+		Topics:   splitList(os.Getenv("PROTECTED_TOPICS")),
+	}
+	for _, pattern := range p.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid PROTECTED_REPOSITORIES pattern %q: %w", pattern, err)
+		}
+	}
+
+	var err error
+	if p.MinStars, err = intFromEnv("PROTECTED_MIN_STARS"); err != nil {
+		return nil, err
+	}
+	if p.MinForks, err = intFromEnv("PROTECTED_MIN_FORKS"); err != nil {
+		return nil, err
+	}
+	if value := os.Getenv("PROTECTED_RECENT_PUSH"); value != "" {
+		if p.RecentPush, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid PROTECTED_RECENT_PUSH: %w", err)
+		}
+	}
+
+	if len(p.Patterns) == 0 && len(p.Topics) == 0 && p.MinStars == 0 && p.MinForks == 0 && p.RecentPush == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// Check returns a *ProtectedError if the policy protects repo. A nil policy
+// protects nothing.
+func (p *Policy) Check(repo *repository.Repository) error {
+	if reason := p.reason(repo, time.Now()); reason != "" {
+		return &ProtectedError{Reason: reason}
+	}
+	return nil
+}
+
+func (p *Policy) reason(repo *repository.Repository, now time.Time) string {
+	if p == nil {
+		return ""
+	}
+
+	fullName := strings.ToLower(repo.FullName)
+	for _, pattern := range p.Patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), fullName); ok {
+			return fmt.Sprintf("matches protected pattern %q", pattern)
+		}
+	}
+	for _, topic := range repo.Topics {
+		for _, protected := range p.Topics {
+			if strings.EqualFold(topic, protected) {
+				return fmt.Sprintf("has protected topic %q", topic)
+			}
+		}
+	}
+	if p.MinStars > 0 && repo.StargazersCount >= p.MinStars {
+		return fmt.Sprintf("has %d stars (protected from %d)", repo.StargazersCount, p.MinStars)
+	}
+	if p.MinForks > 0 && repo.ForksCount >= p.MinForks {
+		return fmt.Sprintf("has %d forks (protected from %d)", repo.ForksCount, p.MinForks)
+	}
+	if p.RecentPush > 0 {
+		if pushedAt, err := time.Parse(time.RFC3339, repo.PushedAt); err == nil && now.Sub(pushedAt) < p.RecentPush {
+			return fmt.Sprintf("pushed at %s, within the protected %s", repo.PushedAt, p.RecentPush)
+		}
+	}
+	return ""
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func intFromEnv(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/repository"
+)
+
+func TestPolicyRules(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	repo := func(change func(repo *repository.Repository)) *repository.Repository {
+		repo := &repository.Repository{
+			FullName:        "octo/demo",
+			Topics:          []string{"tools"},
+			StargazersCount: 5,
+			ForksCount:      1,
+			PushedAt:        now.Add(-30 * 24 * time.Hour).Format(time.RFC3339),
+		}
+		if change != nil {
+			change(repo)
+		}
+		return repo
+	}
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		repo   *repository.Repository
+		want   string
+	}{
+		{name: "nil policy", repo: repo(nil)},
+		{name: "empty policy", policy: &Policy{}, repo: repo(nil)},
+		{name: "owner glob", policy: &Policy{Patterns: []string{"octo/*"}}, repo: repo(nil), want: `matches protected pattern "octo/*"`},
+		{name: "name glob", policy: &Policy{Patterns: []string{"*/prod-*"}}, repo: repo(func(r *repository.Repository) { r.FullName = "acme/prod-api" }), want: `"*/prod-*"`},
+		{name: "glob ignores case", policy: &Policy{Patterns: []string{"Octo/Demo"}}, repo: repo(nil), want: "protected pattern"},
+		{name: "glob does not cross owners", policy: &Policy{Patterns: []string{"acme/*"}}, repo: repo(nil)},
+		{name: "exact name", policy: &Policy{Patterns: []string{"octo/demo-2"}}, repo: repo(nil)},
+		{name: "topic", policy: &Policy{Topics: []string{"keep"}}, repo: repo(func(r *repository.Repository) { r.Topics = []string{"go", "Keep"} }), want: `has protected topic "Keep"`},
+		{name: "other topic", policy: &Policy{Topics: []string{"keep"}}, repo: repo(nil)},
+		{name: "no topics", policy: &Policy{Topics: []string{"keep"}}, repo: repo(func(r *repository.Repository) { r.Topics = nil })},
+		{name: "min stars reached", policy: &Policy{MinStars: 5}, repo: repo(nil), want: "has 5 stars (protected from 5)"},
+		{name: "below min stars", policy: &Policy{MinStars: 6}, repo: repo(nil)},
+		{name: "min forks reached", policy: &Policy{MinForks: 1}, repo: repo(nil), want: "has 1 forks (protected from 1)"},
+		{name: "below min forks", policy: &Policy{MinForks: 2}, repo: repo(nil)},
+		{name: "recent push", policy: &Policy{RecentPush: 31 * 24 * time.Hour}, repo: repo(nil), want: "within the protected 744h0m0s"},
+		{name: "old push", policy: &Policy{RecentPush: 29 * 24 * time.Hour}, repo: repo(nil)},
+		{name: "never pushed", policy: &Policy{RecentPush: time.Hour}, repo: repo(func(r *repository.Repository) { r.PushedAt = "" })},
+		{
+			name:   "one matching rule is enough",
+			policy: &Policy{Patterns: []string{"acme/*"}, Topics: []string{"keep"}, MinStars: 100, MinForks: 1, RecentPush: time.Hour},
+			repo:   repo(nil),
+			want:   "has 1 forks",
+		},
+		{
+			name:   "no rule matches",
+			policy: &Policy{Patterns: []string{"acme/*"}, Topics: []string{"keep"}, MinStars: 100, MinForks: 10, RecentPush: time.Hour},
+			repo:   repo(nil),
+		},
+		{
+			name:   "first matching rule is reported",
+			policy: &Policy{Patterns: []string{"octo/*"}, Topics: []string{"tools"}, MinStars: 1},
+			repo:   repo(nil),
+			want:   "protected pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.reason(tt.repo, now)
+			if (got == "") != (tt.want == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("reason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := &Policy{Topics: []string{"keep"}}
+	err := p.Check(&repository.Repository{FullName: "octo/demo", Topics: []string{"keep"}})
+	var protected *ProtectedError
+	if !errors.As(err, &protected) || !errors.Is(err, ErrProtected) || protected.Reason != `has protected topic "keep"` {
+		t.Errorf("Check = %v", err)
+	}
+	if err := p.Check(&repository.Repository{FullName: "octo/demo"}); err != nil {
+		t.Errorf("Check of an unprotected repository = %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    *Policy
+		wantErr string
+	}{
+		{name: "unset", env: map[string]string{}},
+		{
+			name: "all rules",
+			env: map[string]string{
+				"PROTECTED_REPOSITORIES": "acme/*, */prod-* ,",
+				"PROTECTED_TOPICS":       "keep",
+				"PROTECTED_MIN_STARS":    "100",
+				"PROTECTED_MIN_FORKS":    "10",
+				"PROTECTED_RECENT_PUSH":  "72h",
+			},
+			want: &Policy{Patterns: []string{"acme/*", "*/prod-*"}, Topics: []string{"keep"}, MinStars: 100, MinForks: 10, RecentPush: 72 * time.Hour},
+		},
+		{name: "bad pattern", env: map[string]string{"PROTECTED_REPOSITORIES": "acme/["}, wantErr: "invalid PROTECTED_REPOSITORIES pattern"},
+		{name: "negative stars", env: map[string]string{"PROTECTED_MIN_STARS": "-1"}, wantErr: "invalid PROTECTED_MIN_STARS"},
+		{name: "bad forks", env: map[string]string{"PROTECTED_MIN_FORKS": "many"}, wantErr: "invalid PROTECTED_MIN_FORKS"},
+		{name: "bad duration", env: map[string]string{"PROTECTED_RECENT_PUSH": "3 days"}, wantErr: "invalid PROTECTED_RECENT_PUSH"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"PROTECTED_REPOSITORIES", "PROTECTED_TOPICS", "PROTECTED_MIN_STARS", "PROTECTED_MIN_FORKS", "PROTECTED_RECENT_PUSH"} {
+				t.Setenv(name, tt.env[name])
+			}
+			got, err := FromEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("FromEnv = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromEnv = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
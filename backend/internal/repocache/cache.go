@@ -0,0 +1,322 @@
+package repocache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultFullSyncInterval = time.Hour
+	// incrementalSkew widens the incremental window so clock differences
+	// between us and GitHub can't make us miss a change.
+	incrementalSkew = 2 * time.Minute
+)
+
+var ErrNotCached = errors.New("repositories not cached")
+
+var cacheBucket = []byte("repository_cache")
+
+// Snapshot is the cached repository listing of one user, or of one
+// organization as seen by that user.
+type Snapshot struct {
+	UserID       int                     `json:"user_id"`
+	Org          string                  `json:"org,omitempty"`
+	Repositories []repository.Repository `json:"repositories"`
+	SyncedAt     time.Time               `json:"synced_at"`
+	FullSyncAt   time.Time               `json:"full_sync_at"`
+}
+
+// Cache keeps each user's repository listing in the embedded database so the
+// list endpoint doesn't have to go to GitHub on every request.
+type Cache struct {
+	db               *bolt.DB
+	fullSyncInterval time.Duration
+
+	mu    sync.Mutex
+	locks map[int]*sync.Mutex
+}
+
+func New(db *bolt.DB, fullSyncInterval time.Duration) (*Cache, error) {
+	if err := storage.EnsureBuckets(db, cacheBucket); err != nil {
+		return nil, fmt.Errorf("failed to initialize repository cache: %w", err)
+	}
+	if fullSyncInterval <= 0 {
+		fullSyncInterval = defaultFullSyncInterval
+	}
+	return &Cache{db: db, fullSyncInterval: fullSyncInterval, locks: make(map[int]*sync.Mutex)}, nil
+}
+
+func (c *Cache) Get(userID int) (*Snapshot, error) {
+	return c.get(userID, "")
+}
+
+// GetOrg returns the user's snapshot of an organization's repositories.
+func (c *Cache) GetOrg(userID int, org string) (*Snapshot, error) {
+	return c.get(userID, org)
+}
+
+func (c *Cache) get(userID int, org string) (*Snapshot, error) {
+	var snapshot *Snapshot
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(cacheBucket).Get(cacheKey(userID, org))
+		if data == nil {
+			return ErrNotCached
+		}
+		snapshot = &Snapshot{}
+		return json.Unmarshal(data, snapshot)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Sync refreshes a user's snapshot. It does a full listing when there is no
+// snapshot or the last full sync is too old (the only way to notice repos
+// deleted or transferred outside this app); otherwise it only fetches repos
+// updated or pushed since the last sync.
+func (c *Cache) Sync(ctx context.Context, userID int, client *repository.GitHubClient) (*Snapshot, error) {
+	return c.sync(ctx, userID, "", client)
+}
+
+// SyncOrg is like Sync for the user's snapshot of an organization.
+func (c *Cache) SyncOrg(ctx context.Context, userID int, org string, client *repository.GitHubClient) (*Snapshot, error) {
+	return c.sync(ctx, userID, org, client)
+}
+
+func (c *Cache) sync(ctx context.Context, userID int, org string, client *repository.GitHubClient) (*Snapshot, error) {
+	lock := c.userLock(userID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	list := func(sortKey string) *repository.RepositoryIterator {
+		opts := repository.ListOptions{PerPage: 100, Sort: sortKey}
+		if org != "" {
+			return client.OrganizationRepositories(org, opts)
+		}
+		return client.Repositories(opts)
+	}
+
+	now := time.Now()
+	snapshot, err := c.get(userID, org)
+	if err != nil && !errors.Is(err, ErrNotCached) {
+		return nil, err
+	}
+
+	if snapshot == nil || now.Sub(snapshot.FullSyncAt) >= c.fullSyncInterval {
+		repos, err := list("updated").All(ctx)
+		if err != nil {
+			return nil, err
+		}
+		snapshot = &Snapshot{UserID: userID, Org: org, Repositories: repos, SyncedAt: now, FullSyncAt: now}
+	} else {
+		cutoff := snapshot.SyncedAt.Add(-incrementalSkew)
+		changed := make(map[int]repository.Repository)
+		for _, sortKey := range []string{"updated", "pushed"} {
+			if err := collectChangedSince(ctx, list(sortKey), sortKey, cutoff, changed); err != nil {
+				return nil, err
+			}
+		}
+		snapshot.Repositories = merge(snapshot.Repositories, changed)
+		snapshot.SyncedAt = now
+	}
+
+	if err := c.save(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// collectChangedSince walks the listing newest-first by sortKey and stops at
+// the first repository last changed before cutoff.
+func collectChangedSince(ctx context.Context, it *repository.RepositoryIterator, sortKey string, cutoff time.Time, changed map[int]repository.Repository) error {
+	for it.Next(ctx) {
+		repo := it.Repository()
+		timestamp := repo.UpdatedAt
+		if sortKey == "pushed" {
+			timestamp = repo.PushedAt
+		}
+		if changedAt, err := time.Parse(time.RFC3339, timestamp); err == nil && changedAt.Before(cutoff) {
+			break
+		}
+		changed[repo.ID] = repo
+	}
+	return it.Err()
+}
+
+// Put replaces a repository in the user's snapshot, and in their snapshot of
+// its organization if any, after we changed it ourselves, so the cache
+// reflects the change without waiting for a sync.
+func (c *Cache) Put(userID int, repo repository.Repository) error {
+	return c.mutate(userID, repo.FullName, func(snapshot *Snapshot) {
+		snapshot.Repositories = merge(snapshot.Repositories, map[int]repository.Repository{repo.ID: repo})
+	})
+}
+
+// Remove drops a repository we deleted from the user's snapshots. Names
+// are matched case-insensitively, like GitHub does.
+func (c *Cache) Remove(userID int, fullName string) error {
+	return c.mutate(userID, fullName, func(snapshot *Snapshot) {
+		kept := snapshot.Repositories[:0]
+		for _, repo := range snapshot.Repositories {
+			if !strings.EqualFold(repo.FullName, fullName) {
+				kept = append(kept, repo)
+			}
+		}
+		snapshot.Repositories = kept
+	})
+}
+
+// mutate applies a change to the snapshots that may list fullName: the
+// user's own and the one of the repository's owner, if it is an
+// organization the user's snapshot was taken of.
+func (c *Cache) mutate(userID int, fullName string, apply func(snapshot *Snapshot)) error {
+	lock := c.userLock(userID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	orgs := []string{""}
+	if owner, _, ok := strings.Cut(fullName, "/"); ok {
+		orgs = append(orgs, owner)
+	}
+	for _, org := range orgs {
+		snapshot, err := c.get(userID, org)
+		if errors.Is(err, ErrNotCached) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		apply(snapshot)
+		if err := c.save(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orgs returns the organizations the user has a snapshot of.
+func (c *Cache) orgs(userID int) ([]string, error) {
+	var orgs []string
+	err := c.db.View(func(tx *bolt.Tx) error {
+		var snapshot Snapshot
+		prefix := []byte(strconv.Itoa(userID) + "/")
+		cursor := tx.Bucket(cacheBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && strings.HasPrefix(string(key), string(prefix)); key, data = cursor.Next() {
+			if err := json.Unmarshal(data, &snapshot); err != nil {
+				return fmt.Errorf("failed to decode repository cache: %w", err)
+			}
+			orgs = append(orgs, snapshot.Org)
+		}
+		return nil
+	})
+	return orgs, err
+}
+
+func (c *Cache) save(snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode repository cache: %w", err)
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheBucket).Put(cacheKey(snapshot.UserID, snapshot.Org), data)
+	})
+}
+
+func (c *Cache) userLock(userID int) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lock, ok := c.locks[userID]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[userID] = lock
+	}
+	return lock
+}
+
+// StartSyncWorker syncs the cache of every user returned by users, and
+// their cached organizations, on each interval until ctx is cancelled.
+// client returns nil for users without a usable token.
+func (c *Cache) StartSyncWorker(ctx context.Context, interval time.Duration, users func() ([]int, error), client func(userID int) *repository.GitHubClient) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		userIDs, err := users()
+		if err != nil {
+			log.Printf("Repository sync: failed to list users: %v", err)
+			continue
+		}
+		for _, userID := range userIDs {
+			githubClient := client(userID)
+			if githubClient == nil {
+				continue
+			}
+			if _, err := c.Sync(ctx, userID, githubClient); err != nil {
+				log.Printf("Repository sync failed for user %d: %v", userID, err)
+			}
+
+			orgs, err := c.orgs(userID)
+			if err != nil {
+				log.Printf("Repository sync: failed to list organizations of user %d: %v", userID, err)
+				continue
+			}
+			for _, org := range orgs {
+				if _, err := c.SyncOrg(ctx, userID, org, githubClient); err != nil {
+					log.Printf("Repository sync failed for organization %s of user %d: %v", org, userID, err)
+				}
+			}
+		}
+	}
+}
+
+// merge overlays changed repositories onto repos, keeping the listing sorted
+// by most recently updated like GitHub's sort=updated.
+func merge(repos []repository.Repository, changed map[int]repository.Repository) []repository.Repository {
+	merged := make([]repository.Repository, 0, len(repos)+len(changed))
+	for _, repo := range repos {
+		if _, ok := changed[repo.ID]; !ok {
+			merged = append(merged, repo)
+		}
+	}
+	for _, repo := range changed {
+		merged = append(merged, repo)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].UpdatedAt != merged[j].UpdatedAt {
+			return merged[i].UpdatedAt > merged[j].UpdatedAt
+		}
+		return merged[i].ID < merged[j].ID
+	})
+	return merged
+}
+
+// cacheKey is the user ID for the user's own listing and "<user ID>/<org>"
+// for organizations, whose logins are case-insensitive.
+func cacheKey(userID int, org string) []byte {
+	if org == "" {
+		return []byte(strconv.Itoa(userID))
+	}
+	return []byte(strconv.Itoa(userID) + "/" + strings.ToLower(org))
+}
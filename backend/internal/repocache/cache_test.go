@@ -0,0 +1,349 @@
+package repocache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/repository/githubtest"
+	"github-repo-manager/internal/storage"
+)
+
+// pageSize is small so listings span several pages.
+const pageSize = 2
+
+// fakeGitHub lists the user's or an organization's repositories newest first
+// by the requested sort, pageSize at a time, and records which pages were
+// fetched.
+type fakeGitHub struct {
+	url string
+
+	mu    sync.Mutex
+	repos []repository.Repository
+	pages []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	org, isOrg := strings.CutPrefix(r.URL.Path, "/orgs/")
+	org, hasRepos := strings.CutSuffix(org, "/repos")
+	isOrg = isOrg && hasRepos
+	if r.URL.Path != "/user/repos" && !isOrg {
+		http.NotFound(w, r)
+		return
+	}
+	sortKey := r.URL.Query().Get("sort")
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pages = append(f.pages, fmt.Sprintf("%s:%d", sortKey, page))
+
+	var repos []repository.Repository
+	for _, repo := range f.repos {
+		if owner, _, _ := strings.Cut(repo.FullName, "/"); !isOrg || strings.EqualFold(owner, org) {
+			repos = append(repos, repo)
+		}
+	}
+	sort.SliceStable(repos, func(i, j int) bool {
+		if sortKey == "pushed" {
+			return repos[i].PushedAt > repos[j].PushedAt
+		}
+		return repos[i].UpdatedAt > repos[j].UpdatedAt
+	})
+	start := (page - 1) * pageSize
+	if start > len(repos) {
+		start = len(repos)
+	}
+	end := start + pageSize
+	if end < len(repos) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s?sort=%s&page=%d>; rel="next"`, f.url, r.URL.Path, sortKey, page+1))
+	} else {
+		end = len(repos)
+	}
+	json.NewEncoder(w).Encode(repos[start:end])
+}
+
+// fetched returns the pages fetched since the last call.
+func (f *fakeGitHub) fetched() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pages := f.pages
+	f.pages = nil
+	return pages
+}
+
+func (f *fakeGitHub) set(repos ...repository.Repository) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.repos = repos
+}
+
+func newFakeGitHub(t *testing.T, repos ...repository.Repository) (*fakeGitHub, *repository.GitHubClient) {
+	t.Helper()
+	t.Setenv("GITHUB_LIST_BACKEND", "")
+	fake := &fakeGitHub{repos: repos}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	fake.url = server.URL
+	return fake, githubtest.NewClient(t, server.URL)
+}
+
+func newTestCache(t *testing.T) *Cache {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cache, err := New(db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func testRepo(id int, updated, pushed time.Time) repository.Repository {
+	return repository.Repository{
+		ID:        id,
+		Name:      fmt.Sprintf("repo-%d", id),
+		FullName:  fmt.Sprintf("octo/repo-%d", id),
+		UpdatedAt: updated.UTC().Format(time.RFC3339),
+		PushedAt:  pushed.UTC().Format(time.RFC3339),
+	}
+}
+
+func ids(repos []repository.Repository) []int {
+	ids := make([]int, len(repos))
+	for i, repo := range repos {
+		ids[i] = repo.ID
+	}
+	return ids
+}
+
+func TestSyncFullThenIncremental(t *testing.T) {
+	now := time.Now()
+	days := func(n int) time.Time { return now.AddDate(0, 0, -n) }
+	fake, client := newFakeGitHub(t,
+		testRepo(1, days(10), days(10)),
+		testRepo(2, days(5), days(5)),
+		testRepo(3, days(3), days(3)),
+		testRepo(4, days(20), days(20)),
+		testRepo(5, days(1), days(1)),
+	)
+	cache := newTestCache(t)
+	ctx := context.Background()
+
+	if _, err := cache.Get(1); err != ErrNotCached {
+		t.Fatalf("Get before the first sync = %v, want %v", err, ErrNotCached)
+	}
+
+	// No snapshot yet: a full listing.
+	snapshot, err := cache.Sync(ctx, 1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(snapshot.Repositories), []int{5, 3, 2, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("full sync = %v, want %v", got, want)
+	}
+	if pages, want := fake.fetched(), []string{"updated:1", "updated:2", "updated:3"}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("full sync fetched %v, want %v", pages, want)
+	}
+	fullSyncAt := snapshot.FullSyncAt
+
+	// Repository 2 is updated, 4 pushed to without its update time moving,
+	// 6 is new and 3 deleted.
+	changed := testRepo(2, now, now)
+	changed.Description = "changed"
+	fake.set(
+		testRepo(1, days(10), days(10)),
+		changed,
+		testRepo(4, days(20), now),
+		testRepo(5, days(1), days(1)),
+		testRepo(6, now, now),
+	)
+
+	// The full sync is recent: only what changed since the last sync is fetched,
+	// stopping at the first page that reaches back past it.
+	snapshot, err = cache.Sync(ctx, 1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages, want := fake.fetched(), []string{"updated:1", "updated:2", "pushed:1", "pushed:2"}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("incremental sync fetched %v, want %v", pages, want)
+	}
+	// Deletions aren't noticed until the next full sync.
+	if got, want := ids(snapshot.Repositories), []int{2, 6, 5, 3, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incremental sync = %v, want %v", got, want)
+	}
+	if snapshot.Repositories[0].Description != "changed" || !snapshot.FullSyncAt.Equal(fullSyncAt) {
+		t.Errorf("incremental sync = %+v", snapshot)
+	}
+
+	// Once the full sync is too old, everything is listed again.
+	snapshot.FullSyncAt = now.Add(-2 * time.Hour)
+	if err := cache.save(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err = cache.Sync(ctx, 1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(snapshot.Repositories), []int{2, 6, 5, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second full sync = %v, want %v", got, want)
+	}
+	if pages := fake.fetched(); len(pages) != 3 || pages[0] != "updated:1" {
+		t.Errorf("second full sync fetched %v", pages)
+	}
+
+	cached, err := cache.Get(1)
+	if err != nil || !reflect.DeepEqual(ids(cached.Repositories), ids(snapshot.Repositories)) {
+		t.Errorf("Get = %+v, %v", cached, err)
+	}
+}
+
+func TestCollectChangedSinceStopsAtCutoff(t *testing.T) {
+	now := time.Now()
+	repos := []repository.Repository{
+		testRepo(1, now, now),
+		testRepo(2, now.Add(-time.Hour), now.Add(-time.Hour)),
+		testRepo(3, now.Add(-2*time.Hour), now.Add(-2*time.Hour)),
+		testRepo(4, now.Add(-3*time.Hour), now.Add(-3*time.Hour)),
+		testRepo(5, now.Add(-4*time.Hour), now.Add(-4*time.Hour)),
+		testRepo(6, now.Add(-5*time.Hour), now.Add(-5*time.Hour)),
+	}
+	// Never pushed, so listed last by push time.
+	repos[1].PushedAt = ""
+	fake, client := newFakeGitHub(t, repos...)
+
+	tests := []struct {
+		sortKey string
+		cutoff  time.Duration
+		want    []int
+		pages   []string
+	}{
+		{sortKey: "updated", cutoff: 90 * time.Minute, want: []int{1, 2}, pages: []string{"updated:1", "updated:2"}},
+		{sortKey: "updated", cutoff: 150 * time.Minute, want: []int{1, 2, 3}, pages: []string{"updated:1", "updated:2"}},
+		{sortKey: "updated", cutoff: time.Minute, want: []int{1}, pages: []string{"updated:1"}},
+		{sortKey: "updated", cutoff: 24 * time.Hour, want: []int{1, 2, 3, 4, 5, 6}, pages: []string{"updated:1", "updated:2", "updated:3"}},
+		{sortKey: "pushed", cutoff: 150 * time.Minute, want: []int{1, 3}, pages: []string{"pushed:1", "pushed:2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s since %s", tt.sortKey, tt.cutoff), func(t *testing.T) {
+			changed := make(map[int]repository.Repository)
+			it := client.Repositories(repository.ListOptions{Sort: tt.sortKey})
+			if err := collectChangedSince(context.Background(), it, tt.sortKey, now.Add(-tt.cutoff), changed); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int, 0, len(changed))
+			for id := range changed {
+				got = append(got, id)
+			}
+			sort.Ints(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changed = %v, want %v", got, tt.want)
+			}
+			if pages := fake.fetched(); !reflect.DeepEqual(pages, tt.pages) {
+				t.Errorf("fetched %v, want %v", pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	at := func(id int, updated string) repository.Repository {
+		return repository.Repository{ID: id, UpdatedAt: updated}
+	}
+	repos := []repository.Repository{
+		at(1, "2024-03-01T00:00:00Z"),
+		at(2, "2024-02-01T00:00:00Z"),
+		at(3, "2024-01-01T00:00:00Z"),
+	}
+
+	tests := []struct {
+		name    string
+		changed []repository.Repository
+		want    []int
+	}{
+		{name: "nothing changed", want: []int{1, 2, 3}},
+		{name: "updated moves to the front", changed: []repository.Repository{at(3, "2024-04-01T00:00:00Z")}, want: []int{3, 1, 2}},
+		{name: "new is placed by update time", changed: []repository.Repository{at(4, "2024-02-15T00:00:00Z")}, want: []int{1, 4, 2, 3}},
+		{name: "ties go by ID", changed: []repository.Repository{at(5, "2024-02-01T00:00:00Z"), at(0, "2024-02-01T00:00:00Z")}, want: []int{1, 0, 2, 5, 3}},
+		{
+			name:    "several at once",
+			changed: []repository.Repository{at(2, "2024-05-01T00:00:00Z"), at(6, "2024-04-01T00:00:00Z"), at(1, "2024-03-01T00:00:00Z")},
+			want:    []int{2, 6, 1, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := make(map[int]repository.Repository)
+			for _, repo := range tt.changed {
+				changed[repo.ID] = repo
+			}
+			merged := merge(append([]repository.Repository(nil), repos...), changed)
+			if got := ids(merged); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merge = %v, want %v", got, tt.want)
+			}
+			for _, repo := range merged {
+				if want, ok := changed[repo.ID]; ok && repo.UpdatedAt != want.UpdatedAt {
+					t.Errorf("repository %d kept its old version", repo.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestPutAndRemove(t *testing.T) {
+	now := time.Now()
+	octo := testRepo(1, now.Add(-time.Hour), now)
+	acme := testRepo(2, now.Add(-2*time.Hour), now)
+	acme.FullName = "Acme/Widgets"
+	_, client := newFakeGitHub(t, octo, acme)
+	cache := newTestCache(t)
+	ctx := context.Background()
+	if _, err := cache.Sync(ctx, 1, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.SyncOrg(ctx, 1, "acme", client); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := acme
+	updated.UpdatedAt = now.UTC().Format(time.RFC3339)
+	if err := cache.Put(1, updated); err != nil {
+		t.Fatal(err)
+	}
+	for org, want := range map[string][]int{"": {2, 1}, "acme": {2}} {
+		snapshot, err := cache.get(1, org)
+		if err != nil || !reflect.DeepEqual(ids(snapshot.Repositories), want) || snapshot.Repositories[0].UpdatedAt != updated.UpdatedAt {
+			t.Errorf("snapshot %q after Put = %+v, %v", org, snapshot, err)
+		}
+	}
+
+	// GitHub names are case insensitive.
+	if err := cache.Remove(1, "acme/widgets"); err != nil {
+		t.Fatal(err)
+	}
+	for org, want := range map[string][]int{"": {1}, "acme": {}} {
+		snapshot, err := cache.get(1, org)
+		if err != nil || !reflect.DeepEqual(ids(snapshot.Repositories), want) {
+			t.Errorf("snapshot %q after Remove = %+v, %v", org, snapshot, err)
+		}
+	}
+}
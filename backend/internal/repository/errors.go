@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrValidation   = errors.New("validation failed")
+)
+
+// APIError is a non-successful GitHub API response. It unwraps to one of the
+// sentinel errors above so callers can use errors.Is.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Kind       error
+	// RetryAfter is how long GitHub asked us to wait before retrying, taken
+	// from Retry-After or X-RateLimit-Reset. Zero if it didn't say.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("GitHub API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("GitHub API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// newAPIError builds an APIError from a response, reading GitHub's JSON
+// error message from the body.
+func newAPIError(resp *http.Response) *APIError {
+	var body struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(data, &body); err != nil {
+		body.Message = strings.TrimSpace(string(data))
+	}
+
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: body.Message, RetryAfter: retryAfter(resp)}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		apiErr.Kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusTooManyRequests, isRateLimitResponse(resp, body.Message):
+		apiErr.Kind = ErrRateLimited
+	case resp.StatusCode == http.StatusForbidden:
+		apiErr.Kind = ErrForbidden
+	case resp.StatusCode == http.StatusNotFound:
+		apiErr.Kind = ErrNotFound
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		apiErr.Kind = ErrValidation
+	}
+	return apiErr
+}
+
+// isRateLimitResponse recognizes GitHub's primary (exhausted quota) and
+// secondary (abuse detection) rate limit responses, which arrive as 403s.
+func isRateLimitResponse(resp *http.Response, message string) bool {
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(message), "rate limit")
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if wait := time.Until(time.Unix(reset, 0)); wait > 0 {
+				return wait
+			}
+		}
+	}
+	return 0
+}
@@ -1,96 +1,572 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github-repo-manager/internal/config"
 	"golang.org/x/oauth2"
 )
 
 type Repository struct {
+	ID              int      `json:"id"`
+	Name            string   `json:"name"`
+	FullName        string   `json:"full_name"`
+	Description     string   `json:"description"`
+	Private         bool     `json:"private"`
+	Archived        bool     `json:"archived"`
+	Fork            bool     `json:"fork"`
+	HTMLURL         string   `json:"html_url"`
+	CloneURL        string   `json:"clone_url"`
+	CreatedAt       string   `json:"created_at"`
+	UpdatedAt       string   `json:"updated_at"`
+	PushedAt        string   `json:"pushed_at"`
+	Size            int      `json:"size"`
+	StargazersCount int      `json:"stargazers_count"`
+	WatchersCount   int      `json:"watchers_count"`
+	Language        string   `json:"language"`
+	ForksCount      int      `json:"forks_count"`
+	OpenIssuesCount int      `json:"open_issues_count"`
+	DefaultBranch   string   `json:"default_branch"`
+	Topics          []string `json:"topics"`
+	Owner           Owner    `json:"owner"`
+	// Visibility is "public", "private" or, on GitHub Enterprise,
+	// "internal".
+	Visibility  string   `json:"visibility"`
+	Homepage    string   `json:"homepage"`
+	License     *License `json:"license"`
+	HasIssues   bool     `json:"has_issues"`
+	HasProjects bool     `json:"has_projects"`
+	HasWiki     bool     `json:"has_wiki"`
+	IsTemplate  bool     `json:"is_template"`
+	Disabled    bool     `json:"disabled"`
+	// Permissions are the authenticated user's on the repository.
+	Permissions *Permissions `json:"permissions,omitempty"`
+
+	// Only listed by the GraphQL backend.
+	Languages              map[string]int `json:"languages,omitempty"`
+	DefaultBranchProtected *bool          `json:"default_branch_protected,omitempty"`
+	LastCommitAt           string         `json:"last_commit_at,omitempty"`
+}
+
+type License struct {
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	SPDXID string `json:"spdx_id"`
+}
+
+type Permissions struct {
+	Admin    bool `json:"admin"`
+	Maintain bool `json:"maintain"`
+	Push     bool `json:"push"`
+	Triage   bool `json:"triage"`
+	Pull     bool `json:"pull"`
+}
+
+// CanAdminister reports whether the user may change the repository's
+// visibility, archive it or delete it. Unknown permissions are left for
+// GitHub to decide.
+func (r Repository) CanAdminister() bool {
+	return r.Permissions == nil || r.Permissions.Admin
+}
+
+type Owner struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
+	HTMLURL   string `json:"html_url"`
+}
+
+// Organization is an organization the user is an active member of, with
+// their role in it ("admin" or "member").
+type Organization struct {
 	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	FullName    string `json:"full_name"`
+	Login       string `json:"login"`
 	Description string `json:"description"`
-	Private     bool   `json:"private"`
-	Archived    bool   `json:"archived"`
-	HTMLURL     string `json:"html_url"`
-	CloneURL    string `json:"clone_url"`
-	CreatedAt   string `json:"created_at"`
-	UpdatedAt   string `json:"updated_at"`
-	PushedAt    string `json:"pushed_at"`
-	Size        int    `json:"size"`
-	StargazersCount int `json:"stargazers_count"`
-	WatchersCount   int `json:"watchers_count"`
-	Language        string `json:"language"`
-	ForksCount      int `json:"forks_count"`
-	OpenIssuesCount int `json:"open_issues_count"`
-	DefaultBranch   string `json:"default_branch"`
-	Owner           Owner `json:"owner"`
+	AvatarURL   string `json:"avatar_url"`
+	Role        string `json:"role"`
 }
 
-type Owner struct {
+type User struct {
+	ID        int    `json:"id"`
 	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
 	AvatarURL string `json:"avatar_url"`
 	HTMLURL   string `json:"html_url"`
 }
 
+// RepositoryUpdate is the subset of repository settings we change. Nil
+// fields are left untouched.
+type RepositoryUpdate struct {
+	Private  *bool `json:"private,omitempty"`
+	Archived *bool `json:"archived,omitempty"`
+}
+
+func (u RepositoryUpdate) IsEmpty() bool {
+	return u.Private == nil && u.Archived == nil
+}
+
+type ListOptions struct {
+	PerPage int
+	Sort    string
+}
+
+const userAgent = "GitHub-Repository-Manager"
+
+// GitHubClient is the single typed client for the GitHub REST API. Every
+// method returns an *APIError for non-successful responses.
 type GitHubClient struct {
-	httpClient *http.Client
+	httpClient  *http.Client
+	baseURL     string
+	graphqlURL  string
+	listBackend string
+	token       *oauth2.Token
+	rateLimits  *RateLimitTracker
+}
+
+// ClientOptions is the state a client shares with the other clients created
+// with the same options.
+type ClientOptions struct {
+	// RateLimits tracks the rate limits of the client's token. nil gives the
+	// client a tracker of its own.
+	RateLimits *RateLimitTracker
+	// Cache keeps GET responses for revalidation. nil disables caching.
+	Cache *ResponseCache
 }
 
-func NewGitHubClient(token *oauth2.Token, config *oauth2.Config) *GitHubClient {
+// NewGitHubClient creates a client for the configured GitHub API (see
+// config.GitHub) authenticated with the user's OAuth token. Requests wait
+// out the token's rate limit and are retried on transient errors, and GET
+// responses are cached in opts.Cache and revalidated with conditional
+// requests.
+func NewGitHubClient(token *oauth2.Token, oauthConfig *oauth2.Config, opts ClientOptions) *GitHubClient {
+	if opts.RateLimits == nil {
+		opts.RateLimits = NewRateLimitTracker()
+	}
+	httpClient := oauthConfig.Client(context.Background(), token)
+	httpClient.Transport = newConditionalTransport(newRateLimitTransport(httpClient.Transport, opts.RateLimits, token.AccessToken), opts.Cache, token.AccessToken)
+	endpoints := config.GitHub()
 	return &GitHubClient{
-		httpClient: config.Client(context.Background(), token),
+		httpClient:  httpClient,
+		baseURL:     endpoints.APIURL,
+		graphqlURL:  endpoints.GraphQLURL,
+		listBackend: config.RepositoryListBackend(),
+		token:       token,
+		rateLimits:  opts.RateLimits,
 	}
 }
 
-func (g *GitHubClient) GetRepositories() ([]Repository, error) {
-	resp, err := g.httpClient.Get("https://api.github.com/user/repos?per_page=100&sort=updated")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get repositories: %w", err)
+// AccessToken returns the OAuth token the client authenticates with, for
+// git operations that can't go through the API client.
+func (g *GitHubClient) AccessToken() string {
+	if g.token == nil {
+		return ""
+	}
+	return g.token.AccessToken
+}
+
+// RateLimitStatus returns the token's rate limits as of the last responses.
+func (g *GitHubClient) RateLimitStatus() RateLimitStatus {
+	return g.rateLimits.status(rateLimitKey(g.AccessToken()))
+}
+
+// RefreshRateLimit asks GitHub for the token's current rate limits, which
+// doesn't count against them, and returns the updated status.
+func (g *GitHubClient) RefreshRateLimit(ctx context.Context) (RateLimitStatus, error) {
+	var body struct {
+		Resources map[string]struct {
+			Limit     int   `json:"limit"`
+			Remaining int   `json:"remaining"`
+			Used      int   `json:"used"`
+			Reset     int64 `json:"reset"`
+		} `json:"resources"`
+	}
+	if _, err := g.do(ctx, http.MethodGet, "/rate_limit", nil, &body); err != nil {
+		return RateLimitStatus{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	key := rateLimitKey(g.AccessToken())
+	now := time.Now()
+	for name, resource := range body.Resources {
+		g.rateLimits.set(key, RateLimit{
+			Resource:  name,
+			Limit:     resource.Limit,
+			Remaining: resource.Remaining,
+			Used:      resource.Used,
+			Reset:     time.Unix(resource.Reset, 0),
+			UpdatedAt: now,
+		})
 	}
+	return g.rateLimits.status(key), nil
+}
 
+func (g *GitHubClient) GetUser(ctx context.Context) (*User, error) {
+	var user User
+	if _, err := g.do(ctx, http.MethodGet, "/user", nil, &user); err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", err)
+	}
+	return &user, nil
+}
+
+// listRepositoryPage fetches one page of a repository listing and returns
+// the URL of the next page from the Link header, "" on the last page.
+func (g *GitHubClient) listRepositoryPage(ctx context.Context, path string) ([]Repository, string, error) {
 	var repos []Repository
-	if err := json.NewDecoder(resp.Body).Decode(&repos); err != nil {
-		return nil, fmt.Errorf("failed to decode repositories: %w", err)
+	resp, err := g.do(ctx, http.MethodGet, path, nil, &repos)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get repositories: %w", err)
+	}
+	return repos, parseLinkHeader(resp.Header.Get("Link"))["next"], nil
+}
+
+// RepositoryIterator walks a repository listing page by page until there
+// are no pages left.
+type RepositoryIterator struct {
+	// fetch returns the page at cursor and the cursor of the next page, ""
+	// after the last one.
+	fetch   func(ctx context.Context, cursor string) ([]Repository, string, error)
+	cursor  string
+	done    bool
+	page    []Repository
+	current Repository
+	err     error
+}
+
+// Repositories returns an iterator over every repository of the user
+// matching opts, listed with the REST API or, if so configured, the GraphQL
+// API. opts.PerPage only sets the page size used to fetch.
+func (g *GitHubClient) Repositories(opts ListOptions) *RepositoryIterator {
+	return g.repositories("", opts)
+}
+
+// OrganizationRepositories is like Repositories for the repositories of an
+// organization the user can see.
+func (g *GitHubClient) OrganizationRepositories(org string, opts ListOptions) *RepositoryIterator {
+	return g.repositories(org, opts)
+}
+
+func (g *GitHubClient) repositories(org string, opts ListOptions) *RepositoryIterator {
+	perPage := opts.PerPage
+	if perPage <= 0 || perPage > 100 {
+		perPage = 100
+	}
+	if g.listBackend == config.ListBackendGraphQL {
+		return g.graphqlRepositories(org, perPage, opts.Sort)
+	}
+
+	query := url.Values{}
+	query.Set("per_page", strconv.Itoa(perPage))
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
 	}
+	path := "/user/repos?"
+	if org != "" {
+		query.Set("type", "all")
+		path = "/orgs/" + url.PathEscape(org) + "/repos?"
+	}
+	it := &RepositoryIterator{cursor: path + query.Encode()}
+	it.fetch = func(ctx context.Context, path string) ([]Repository, string, error) {
+		repos, nextURL, err := g.listRepositoryPage(ctx, path)
+		if err != nil {
+			return nil, "", err
+		}
+		next, err := g.relativePath(nextURL)
+		return repos, next, err
+	}
+	return it
+}
 
+// Next advances to the next repository, fetching the next page when needed.
+// It returns false when the listing is exhausted or a request failed.
+func (it *RepositoryIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		page, next, err := it.fetch(ctx, it.cursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.cursor, it.done = page, next, next == ""
+	}
+
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *RepositoryIterator) Repository() Repository {
+	return it.current
+}
+
+func (it *RepositoryIterator) Err() error {
+	return it.err
+}
+
+// All collects the rest of the listing.
+func (it *RepositoryIterator) All(ctx context.Context) ([]Repository, error) {
+	repos := make([]Repository, 0)
+	for it.Next(ctx) {
+		repos = append(repos, it.Repository())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
 	return repos, nil
 }
 
-func (g *GitHubClient) UpdateRepository(owner, repo string, updates map[string]interface{}) (*Repository, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s", owner, repo)
-	
-	// Implementation will be added when we implement the actual update functionality
-	// This is a placeholder for now
-	return nil, fmt.Errorf("not implemented yet")
+// ListAllRepositories collects every repository of the listing.
+func (g *GitHubClient) ListAllRepositories(ctx context.Context, opts ListOptions) ([]Repository, error) {
+	return g.Repositories(opts).All(ctx)
+}
+
+// organizationMembership is an entry of /user/memberships/orgs.
+type organizationMembership struct {
+	Role         string `json:"role"`
+	Organization struct {
+		ID          int    `json:"id"`
+		Login       string `json:"login"`
+		Description string `json:"description"`
+		AvatarURL   string `json:"avatar_url"`
+	} `json:"organization"`
+}
+
+func (m organizationMembership) organization() Organization {
+	return Organization{
+		ID:          m.Organization.ID,
+		Login:       m.Organization.Login,
+		Description: m.Organization.Description,
+		AvatarURL:   m.Organization.AvatarURL,
+		Role:        m.Role,
+	}
+}
+
+// ListOrganizations returns the organizations the user is an active member
+// of.
+func (g *GitHubClient) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	items, err := g.listAll(ctx, "/user/memberships/orgs?state=active&per_page=100")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	orgs := make([]Organization, 0, len(items))
+	for _, item := range items {
+		var membership organizationMembership
+		if err := json.Unmarshal(item, &membership); err != nil {
+			return nil, fmt.Errorf("failed to decode organization membership: %w", err)
+		}
+		orgs = append(orgs, membership.organization())
+	}
+	return orgs, nil
+}
+
+// GetOrganization returns an organization the user is a member of, or
+// ErrNotFound if they aren't.
+func (g *GitHubClient) GetOrganization(ctx context.Context, org string) (*Organization, error) {
+	var membership organizationMembership
+	if _, err := g.do(ctx, http.MethodGet, "/user/memberships/orgs/"+url.PathEscape(org), nil, &membership); err != nil {
+		return nil, fmt.Errorf("failed to get organization %s: %w", org, err)
+	}
+	organization := membership.organization()
+	return &organization, nil
+}
+
+func (g *GitHubClient) GetRepository(ctx context.Context, owner, repo string) (*Repository, error) {
+	var repository Repository
+	if _, err := g.do(ctx, http.MethodGet, repoPath(owner, repo), nil, &repository); err != nil {
+		return nil, fmt.Errorf("failed to get repository %s/%s: %w", owner, repo, err)
+	}
+	return &repository, nil
+}
+
+// GetRepositoryByID looks a repository up by its ID, which unlike its name
+// survives renames and transfers.
+func (g *GitHubClient) GetRepositoryByID(ctx context.Context, id int) (*Repository, error) {
+	var repository Repository
+	if _, err := g.do(ctx, http.MethodGet, "/repositories/"+strconv.Itoa(id), nil, &repository); err != nil {
+		return nil, fmt.Errorf("failed to get repository %d: %w", id, err)
+	}
+	return &repository, nil
+}
+
+func (g *GitHubClient) UpdateRepository(ctx context.Context, owner, repo string, updates RepositoryUpdate) (*Repository, error) {
+	var repository Repository
+	if _, err := g.do(ctx, http.MethodPatch, repoPath(owner, repo), updates, &repository); err != nil {
+		return nil, fmt.Errorf("failed to update repository %s/%s: %w", owner, repo, err)
+	}
+	return &repository, nil
+}
+
+func (g *GitHubClient) DeleteRepository(ctx context.Context, owner, repo string) error {
+	if _, err := g.do(ctx, http.MethodDelete, repoPath(owner, repo), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete repository %s/%s: %w", owner, repo, err)
+	}
+	return nil
+}
+
+// GetRepositoryJSON returns the repository exactly as GitHub describes it,
+// including the settings Repository doesn't model.
+func (g *GitHubClient) GetRepositoryJSON(ctx context.Context, owner, repo string) (json.RawMessage, error) {
+	var raw json.RawMessage
+	if _, err := g.do(ctx, http.MethodGet, repoPath(owner, repo), nil, &raw); err != nil {
+		return nil, fmt.Errorf("failed to get repository %s/%s: %w", owner, repo, err)
+	}
+	return raw, nil
+}
+
+func (g *GitHubClient) GetTopics(ctx context.Context, owner, repo string) ([]string, error) {
+	var topics struct {
+		Names []string `json:"names"`
+	}
+	if _, err := g.do(ctx, http.MethodGet, repoPath(owner, repo)+"/topics", nil, &topics); err != nil {
+		return nil, fmt.Errorf("failed to get topics of %s/%s: %w", owner, repo, err)
+	}
+	return topics.Names, nil
+}
+
+// ReplaceTopics sets the repository's topics to exactly names.
+func (g *GitHubClient) ReplaceTopics(ctx context.Context, owner, repo string, names []string) error {
+	body := struct {
+		Names []string `json:"names"`
+	}{Names: names}
+	if body.Names == nil {
+		body.Names = []string{}
+	}
+	if _, err := g.do(ctx, http.MethodPut, repoPath(owner, repo)+"/topics", body, nil); err != nil {
+		return fmt.Errorf("failed to set topics of %s/%s: %w", owner, repo, err)
+	}
+	return nil
+}
+
+func (g *GitHubClient) ListLabels(ctx context.Context, owner, repo string) ([]json.RawMessage, error) {
+	labels, err := g.listAll(ctx, repoPath(owner, repo)+"/labels?per_page=100")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels of %s/%s: %w", owner, repo, err)
+	}
+	return labels, nil
+}
+
+// ListIssues returns every issue, open or closed. GitHub includes pull
+// requests in this listing.
+func (g *GitHubClient) ListIssues(ctx context.Context, owner, repo string) ([]json.RawMessage, error) {
+	issues, err := g.listAll(ctx, repoPath(owner, repo)+"/issues?state=all&per_page=100")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list issues of %s/%s: %w", owner, repo, err)
+	}
+	return issues, nil
+}
+
+func (g *GitHubClient) ListReleases(ctx context.Context, owner, repo string) ([]json.RawMessage, error) {
+	releases, err := g.listAll(ctx, repoPath(owner, repo)+"/releases?per_page=100")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases of %s/%s: %w", owner, repo, err)
+	}
+	return releases, nil
+}
+
+// listAll collects every item of a paginated listing as raw JSON.
+func (g *GitHubClient) listAll(ctx context.Context, path string) ([]json.RawMessage, error) {
+	items := make([]json.RawMessage, 0)
+	for path != "" {
+		var page []json.RawMessage
+		resp, err := g.do(ctx, http.MethodGet, path, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		if path, err = g.relativePath(parseLinkHeader(resp.Header.Get("Link"))["next"]); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// do sends a request to the API and decodes a successful JSON response into
+// out (if non-nil). Non-2xx responses are returned as *APIError.
+func (g *GitHubClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) (*http.Response, error) {
+	return g.doURL(ctx, method, g.baseURL+path, body, out)
 }
 
-func (g *GitHubClient) DeleteRepository(owner, repo string) error {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s", owner, repo)
-	
-	req, err := http.NewRequest("DELETE", url, nil)
+func (g *GitHubClient) doURL(ctx context.Context, method, rawURL string, body interface{}, out interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
 	if err != nil {
-		return fmt.Errorf("failed to create delete request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("User-Agent", userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
 	resp, err := g.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to delete repository: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp, newAPIError(resp)
 	}
 
-	return nil
-}
\ No newline at end of file
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// relativePath turns an absolute URL returned by the API (e.g. a Link header
+// target) into a path under baseURL. Links to other hosts are refused so the
+// token is never sent elsewhere.
+func (g *GitHubClient) relativePath(rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(rawURL, g.baseURL+"/") {
+		return "", fmt.Errorf("unexpected pagination URL %q", rawURL)
+	}
+	return strings.TrimPrefix(rawURL, g.baseURL), nil
+}
+
+func repoPath(owner, repo string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+}
+
+// parseLinkHeader maps rel names to URLs, e.g.
+// <https://api.github.com/user/repos?page=2>; rel="next"
+func parseLinkHeader(header string) map[string]string {
+	links := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		segments := strings.Split(part, ";")
+		if len(segments) < 2 {
+			continue
+		}
+		target := strings.Trim(strings.TrimSpace(segments[0]), "<>")
+		for _, param := range segments[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "rel=") {
+				links[strings.Trim(strings.TrimPrefix(param, "rel="), `"`)] = target
+			}
+		}
+	}
+	return links
+}
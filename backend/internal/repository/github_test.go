@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/config"
+	"golang.org/x/oauth2"
+)
+
+func TestAPIErrorKinds(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := []struct {
+		name   string
+		status int
+		header map[string]string
+		want   error
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, want: ErrUnauthorized},
+		{name: "not found", status: http.StatusNotFound, want: ErrNotFound},
+		{name: "forbidden", status: http.StatusForbidden, want: ErrForbidden},
+		{
+			name:   "rate limit exhausted",
+			status: http.StatusForbidden,
+			header: map[string]string{"X-RateLimit-Limit": "5000", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset},
+			want:   ErrRateLimited,
+		},
+		{name: "validation", status: http.StatusUnprocessableEntity, want: ErrValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range tt.header {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, `{"message":"nope"}`)
+			}))
+			defer server.Close()
+			client := newTestClient(t, server.URL, config.ListBackendREST)
+
+			_, err := client.GetRepository(context.Background(), "octo", "demo")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want an APIError of kind %v", err, tt.want)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != "nope" {
+				t.Errorf("unexpected APIError %+v", apiErr)
+			}
+		})
+	}
+}
+
+func TestRepositoriesFollowsLinkHeader(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user/repos" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "":
+			if r.URL.Query().Get("per_page") != "2" {
+				t.Errorf("per_page = %q, want 2", r.URL.Query().Get("per_page"))
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<%s/user/repos?per_page=2&page=2>; rel="next", <%s/user/repos?per_page=2&page=2>; rel="last"`, server.URL, server.URL))
+			fmt.Fprint(w, `[{"id":1,"full_name":"octo/one"},{"id":2,"full_name":"octo/two"}]`)
+		case "2":
+			fmt.Fprint(w, `[{"id":3,"full_name":"octo/three"}]`)
+		default:
+			t.Errorf("unexpected page %q", r.URL.Query().Get("page"))
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, config.ListBackendREST)
+
+	repos, err := client.ListAllRepositories(context.Background(), ListOptions{PerPage: 2})
+	if err != nil {
+		t.Fatalf("ListAllRepositories: %v", err)
+	}
+	if len(repos) != 3 || repos[0].FullName != "octo/one" || repos[2].FullName != "octo/three" {
+		t.Errorf("got %+v", repos)
+	}
+}
+
+func TestRepositoriesRefusesForeignLink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://evil.example.com/user/repos?page=2>; rel="next"`)
+		fmt.Fprint(w, `[{"id":1,"full_name":"octo/one"}]`)
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, config.ListBackendREST)
+
+	if _, err := client.ListAllRepositories(context.Background(), ListOptions{}); err == nil {
+		t.Fatal("followed a pagination link to another host")
+	}
+}
+
+func TestEnterpriseBaseURL(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v3/repos/octo/demo":
+			fmt.Fprint(w, `{"id":1,"full_name":"octo/demo"}`)
+		case "/api/graphql":
+			fmt.Fprint(w, `{"data":{"owner":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[]}}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("GITHUB_BASE_URL", server.URL+"/")
+	t.Setenv("GITHUB_API_URL", "")
+	t.Setenv("GITHUB_GRAPHQL_URL", "")
+	t.Setenv("GITHUB_LIST_BACKEND", config.ListBackendGraphQL)
+	t.Setenv("GITHUB_MAX_RETRIES", "0")
+	client := NewGitHubClient(&oauth2.Token{AccessToken: "test-token"}, &oauth2.Config{}, ClientOptions{})
+
+	if _, err := client.GetRepository(context.Background(), "octo", "demo"); err != nil {
+		t.Fatalf("GetRepository: %v", err)
+	}
+	if _, err := client.ListAllRepositories(context.Background(), ListOptions{}); err != nil {
+		t.Fatalf("ListAllRepositories: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/api/v3/repos/octo/demo" || paths[1] != "/api/graphql" {
+		t.Errorf("requested %v, want the GHES REST and GraphQL endpoints", paths)
+	}
+}
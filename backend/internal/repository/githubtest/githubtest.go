@@ -0,0 +1,21 @@
+// Package githubtest provides GitHub API clients for tests that run against
+// a stand-in API.
+package githubtest
+
+import (
+	"testing"
+
+	"github-repo-manager/internal/repository"
+	"golang.org/x/oauth2"
+)
+
+// NewClient returns a client for the stand-in GitHub API at apiURL. It
+// never retries, caches nothing and tracks its rate limits on its own, so
+// no state is shared with other tests.
+func NewClient(t testing.TB, apiURL string) *repository.GitHubClient {
+	t.Helper()
+	t.Setenv("GITHUB_BASE_URL", "")
+	t.Setenv("GITHUB_API_URL", apiURL)
+	t.Setenv("GITHUB_MAX_RETRIES", "0")
+	return repository.NewGitHubClient(&oauth2.Token{AccessToken: "test-token"}, &oauth2.Config{}, repository.ClientOptions{})
+}
@@ -0,0 +1,356 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// repositoryFields is everything the REST listing has plus what it would
+// take a request per repository to get.
+const repositoryFields = `fragment repositoryFields on Repository {
+  databaseId
+  name
+  nameWithOwner
+  description
+  isPrivate
+  isArchived
+  isFork
+  isTemplate
+  isDisabled
+  visibility
+  url
+  homepageUrl
+  hasIssuesEnabled
+  hasProjectsEnabled
+  hasWikiEnabled
+  licenseInfo { key name spdxId }
+  createdAt
+  updatedAt
+  pushedAt
+  diskUsage
+  stargazerCount
+  forkCount
+  viewerPermission
+  watchers { totalCount }
+  issues(states: OPEN) { totalCount }
+  primaryLanguage { name }
+  languages(first: 20, orderBy: {field: SIZE, direction: DESC}) { edges { size node { name } } }
+  repositoryTopics(first: 20) { nodes { topic { name } } }
+  owner { login avatarUrl url }
+  defaultBranchRef {
+    name
+    branchProtectionRule { id }
+    target { ... on Commit { committedDate } }
+  }
+}`
+
+// viewerRepositoriesQuery lists the user's repositories. Affiliations match
+// the REST /user/repos default.
+const viewerRepositoriesQuery = `query($first: Int!, $after: String, $orderBy: RepositoryOrder!) {
+  owner: viewer {
+    repositories(first: $first, after: $after, orderBy: $orderBy, ownerAffiliations: [OWNER, COLLABORATOR, ORGANIZATION_MEMBER]) {
+      pageInfo { hasNextPage endCursor }
+      nodes { ...repositoryFields }
+    }
+  }
+}
+` + repositoryFields
+
+// organizationRepositoriesQuery lists the repositories of an organization.
+const organizationRepositoriesQuery = `query($login: String!, $first: Int!, $after: String, $orderBy: RepositoryOrder!) {
+  owner: organization(login: $login) {
+    repositories(first: $first, after: $after, orderBy: $orderBy) {
+      pageInfo { hasNextPage endCursor }
+      nodes { ...repositoryFields }
+    }
+  }
+}
+` + repositoryFields
+
+type graphqlRepository struct {
+	DatabaseID    int    `json:"databaseId"`
+	Name          string `json:"name"`
+	NameWithOwner string `json:"nameWithOwner"`
+	Description   string `json:"description"`
+	IsPrivate     bool   `json:"isPrivate"`
+	IsArchived    bool   `json:"isArchived"`
+	IsFork        bool   `json:"isFork"`
+	IsTemplate    bool   `json:"isTemplate"`
+	IsDisabled    bool   `json:"isDisabled"`
+	// Visibility is PUBLIC, PRIVATE or INTERNAL.
+	Visibility         string `json:"visibility"`
+	URL                string `json:"url"`
+	HomepageURL        string `json:"homepageUrl"`
+	HasIssuesEnabled   bool   `json:"hasIssuesEnabled"`
+	HasProjectsEnabled bool   `json:"hasProjectsEnabled"`
+	HasWikiEnabled     bool   `json:"hasWikiEnabled"`
+	LicenseInfo        *struct {
+		Key    string `json:"key"`
+		Name   string `json:"name"`
+		SpdxID string `json:"spdxId"`
+	} `json:"licenseInfo"`
+	CreatedAt      string `json:"createdAt"`
+	UpdatedAt      string `json:"updatedAt"`
+	PushedAt       string `json:"pushedAt"`
+	DiskUsage      int    `json:"diskUsage"`
+	StargazerCount int    `json:"stargazerCount"`
+	ForkCount      int    `json:"forkCount"`
+	// ViewerPermission is ADMIN, MAINTAIN, WRITE, TRIAGE or READ.
+	ViewerPermission string `json:"viewerPermission"`
+	Watchers         struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"watchers"`
+	Issues struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"issues"`
+	PrimaryLanguage *struct {
+		Name string `json:"name"`
+	} `json:"primaryLanguage"`
+	Languages struct {
+		Edges []struct {
+			Size int `json:"size"`
+			Node struct {
+				Name string `json:"name"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"languages"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+	Owner struct {
+		Login     string `json:"login"`
+		AvatarURL string `json:"avatarUrl"`
+		URL       string `json:"url"`
+	} `json:"owner"`
+	DefaultBranchRef *struct {
+		Name                 string `json:"name"`
+		BranchProtectionRule *struct {
+			ID string `json:"id"`
+		} `json:"branchProtectionRule"`
+		Target struct {
+			CommittedDate string `json:"committedDate"`
+		} `json:"target"`
+	} `json:"defaultBranchRef"`
+}
+
+// repository converts a GraphQL node to the REST shape the rest of the
+// backend uses.
+func (r *graphqlRepository) repository() Repository {
+	repo := Repository{
+		ID:              r.DatabaseID,
+		Name:            r.Name,
+		FullName:        r.NameWithOwner,
+		Description:     r.Description,
+		Private:         r.IsPrivate,
+		Archived:        r.IsArchived,
+		Fork:            r.IsFork,
+		HTMLURL:         r.URL,
+		CloneURL:        r.URL + ".git",
+		CreatedAt:       r.CreatedAt,
+		UpdatedAt:       r.UpdatedAt,
+		PushedAt:        r.PushedAt,
+		Size:            r.DiskUsage,
+		StargazersCount: r.StargazerCount,
+		WatchersCount:   r.Watchers.TotalCount,
+		ForksCount:      r.ForkCount,
+		OpenIssuesCount: r.Issues.TotalCount,
+		Topics:          make([]string, 0, len(r.RepositoryTopics.Nodes)),
+		Owner: Owner{
+			Login:     r.Owner.Login,
+			AvatarURL: r.Owner.AvatarURL,
+			HTMLURL:   r.Owner.URL,
+		},
+		Visibility:  strings.ToLower(r.Visibility),
+		Homepage:    r.HomepageURL,
+		HasIssues:   r.HasIssuesEnabled,
+		HasProjects: r.HasProjectsEnabled,
+		HasWiki:     r.HasWikiEnabled,
+		IsTemplate:  r.IsTemplate,
+		Disabled:    r.IsDisabled,
+		Languages:   make(map[string]int, len(r.Languages.Edges)),
+		Permissions: permissionsFor(r.ViewerPermission),
+	}
+	if license := r.LicenseInfo; license != nil {
+		repo.License = &License{Key: license.Key, Name: license.Name, SPDXID: license.SpdxID}
+	}
+	if r.PrimaryLanguage != nil {
+		repo.Language = r.PrimaryLanguage.Name
+	}
+	for _, edge := range r.Languages.Edges {
+		repo.Languages[edge.Node.Name] = edge.Size
+	}
+	for _, node := range r.RepositoryTopics.Nodes {
+		repo.Topics = append(repo.Topics, node.Topic.Name)
+	}
+	// Empty repositories have no default branch.
+	if ref := r.DefaultBranchRef; ref != nil {
+		protected := ref.BranchProtectionRule != nil
+		repo.DefaultBranch = ref.Name
+		repo.DefaultBranchProtected = &protected
+		repo.LastCommitAt = ref.Target.CommittedDate
+	}
+	return repo
+}
+
+// permissionsFor converts a GraphQL repository permission to the REST
+// permission flags, each level including the ones below it.
+func permissionsFor(permission string) *Permissions {
+	levels := []string{"READ", "TRIAGE", "WRITE", "MAINTAIN", "ADMIN"}
+	level := -1
+	for i, name := range levels {
+		if name == permission {
+			level = i
+		}
+	}
+	if level < 0 {
+		return nil
+	}
+	return &Permissions{
+		Pull:     level >= 0,
+		Triage:   level >= 1,
+		Push:     level >= 2,
+		Maintain: level >= 3,
+		Admin:    level >= 4,
+	}
+}
+
+// graphqlOrder maps REST sort keys to GraphQL orderings, in the direction
+// the REST API sorts them by default.
+var graphqlOrder = map[string]map[string]string{
+	"":          {"field": "UPDATED_AT", "direction": "DESC"},
+	"updated":   {"field": "UPDATED_AT", "direction": "DESC"},
+	"pushed":    {"field": "PUSHED_AT", "direction": "DESC"},
+	"created":   {"field": "CREATED_AT", "direction": "DESC"},
+	"full_name": {"field": "NAME", "direction": "ASC"},
+}
+
+func (g *GitHubClient) graphqlRepositories(org string, perPage int, sort string) *RepositoryIterator {
+	it := &RepositoryIterator{}
+	orderBy, ok := graphqlOrder[sort]
+	if !ok {
+		it.err = fmt.Errorf("unsupported sort %q for the GraphQL backend", sort)
+		return it
+	}
+
+	it.fetch = func(ctx context.Context, cursor string) ([]Repository, string, error) {
+		query := viewerRepositoriesQuery
+		variables := map[string]interface{}{"first": perPage, "orderBy": orderBy}
+		if org != "" {
+			query = organizationRepositoriesQuery
+			variables["login"] = org
+		}
+		if cursor != "" {
+			variables["after"] = cursor
+		}
+
+		var data struct {
+			Owner *struct {
+				Repositories struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []graphqlRepository `json:"nodes"`
+				} `json:"repositories"`
+			} `json:"owner"`
+		}
+		fieldErrors, err := g.graphql(ctx, query, variables, &data)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get repositories: %w", err)
+		}
+		if data.Owner == nil {
+			return nil, "", fmt.Errorf("failed to get repositories: %w", &APIError{StatusCode: http.StatusNotFound, Message: "Not Found", Kind: ErrNotFound})
+		}
+
+		listing := data.Owner.Repositories
+		repos := make([]Repository, 0, len(listing.Nodes))
+		for i := range listing.Nodes {
+			repos = append(repos, listing.Nodes[i].repository())
+		}
+
+		// Branch protection is only visible to admins; to anyone else
+		// it's unknown, not unprotected.
+		for _, fieldErr := range fieldErrors {
+			if i, ok := fieldErr.nodeIndex(); ok && i < len(repos) && fieldErr.at("branchProtectionRule") {
+				repos[i].DefaultBranchProtected = nil
+				continue
+			}
+			log.Printf("GraphQL error at %v: %s", fieldErr.Path, fieldErr.Message)
+		}
+
+		next := ""
+		if listing.PageInfo.HasNextPage {
+			next = listing.PageInfo.EndCursor
+		}
+		return repos, next, nil
+	}
+	return it
+}
+
+type graphqlError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// Path mixes field names and list indexes.
+	Path []interface{} `json:"path"`
+}
+
+// nodeIndex returns i for errors under owner.repositories.nodes[i].
+func (e graphqlError) nodeIndex() (int, bool) {
+	if len(e.Path) < 4 || e.Path[2] != "nodes" {
+		return 0, false
+	}
+	index, ok := e.Path[3].(float64)
+	return int(index), ok
+}
+
+func (e graphqlError) at(field string) bool {
+	return len(e.Path) > 0 && e.Path[len(e.Path)-1] == field
+}
+
+// graphql runs a query and decodes its data into out. A response without
+// data is an error; errors on individual fields, such as branch protection
+// the user may not see, are returned alongside the partial data.
+func (g *GitHubClient) graphql(ctx context.Context, query string, variables map[string]interface{}, out interface{}) ([]graphqlError, error) {
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphqlError  `json:"errors"`
+	}
+	request := map[string]interface{}{"query": query, "variables": variables}
+	if _, err := g.doURL(ctx, http.MethodPost, g.graphqlURL, request, &response); err != nil {
+		return nil, err
+	}
+
+	if len(response.Data) == 0 || string(response.Data) == "null" {
+		if len(response.Errors) == 0 {
+			return nil, fmt.Errorf("empty GraphQL response")
+		}
+		return nil, graphqlAPIError(response.Errors[0])
+	}
+	if err := json.Unmarshal(response.Data, out); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return response.Errors, nil
+}
+
+// graphqlAPIError maps a GraphQL error, which comes with a 200 status, to
+// the APIError a REST response would have produced.
+func graphqlAPIError(graphqlErr graphqlError) *APIError {
+	apiErr := &APIError{StatusCode: http.StatusOK, Message: graphqlErr.Message, Kind: ErrValidation}
+	switch graphqlErr.Type {
+	case "RATE_LIMITED":
+		apiErr.StatusCode, apiErr.Kind = http.StatusTooManyRequests, ErrRateLimited
+	case "FORBIDDEN":
+		apiErr.StatusCode, apiErr.Kind = http.StatusForbidden, ErrForbidden
+	case "NOT_FOUND":
+		apiErr.StatusCode, apiErr.Kind = http.StatusNotFound, ErrNotFound
+	}
+	return apiErr
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/config"
+	"golang.org/x/oauth2"
+)
+
+// newTestClient returns a client for a stand-in API at apiURL, listing
+// with backend and never retrying. Like githubtest.NewClient, which this
+// package can't import, it shares no rate limit state or cache.
+func newTestClient(t *testing.T, apiURL, backend string) *GitHubClient {
+	t.Helper()
+	t.Setenv("GITHUB_BASE_URL", "")
+	t.Setenv("GITHUB_API_URL", apiURL)
+	t.Setenv("GITHUB_GRAPHQL_URL", apiURL+"/graphql")
+	t.Setenv("GITHUB_LIST_BACKEND", backend)
+	t.Setenv("GITHUB_MAX_RETRIES", "0")
+	return NewGitHubClient(&oauth2.Token{AccessToken: "test-token"}, &oauth2.Config{}, ClientOptions{})
+}
+
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// graphqlStandIn serves viewer.repositories in pages: one per entry of
+// pages, each a list of repository nodes as JSON.
+func graphqlStandIn(t *testing.T, pages [][]string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/graphql" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var req graphqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode GraphQL request: %v", err)
+			return
+		}
+
+		page := 0
+		if after, ok := req.Variables["after"].(string); ok {
+			page, _ = strconv.Atoi(after)
+		}
+		if page >= len(pages) {
+			t.Errorf("request for page %d of %d", page, len(pages))
+			return
+		}
+
+		nodes := "[]"
+		if len(pages[page]) > 0 {
+			nodes = "["
+			for i, node := range pages[page] {
+				if i > 0 {
+					nodes += ","
+				}
+				nodes += node
+			}
+			nodes += "]"
+		}
+		hasNext := page+1 < len(pages)
+		fmt.Fprintf(w, `{"data":{"owner":{"repositories":{"pageInfo":{"hasNextPage":%t,"endCursor":"%d"},"nodes":%s}}}}`, hasNext, page+1, nodes)
+	}))
+}
+
+const fullNode = `{
+  "databaseId": 1, "name": "full", "nameWithOwner": "octo/full",
+  "description": "a repository", "isPrivate": true, "isArchived": false,
+  "isFork": false, "isTemplate": true, "isDisabled": false,
+  "visibility": "INTERNAL", "url": "https://github.com/octo/full",
+  "homepageUrl": "https://example.com", "hasIssuesEnabled": true,
+  "hasProjectsEnabled": false, "hasWikiEnabled": true,
+  "licenseInfo": {"key": "mit", "name": "MIT License", "spdxId": "MIT"},
+  "createdAt": "2020-01-01T00:00:00Z", "updatedAt": "2024-01-01T00:00:00Z",
+  "pushedAt": "2023-12-31T00:00:00Z", "diskUsage": 42,
+  "stargazerCount": 3, "forkCount": 2, "viewerPermission": "WRITE",
+  "watchers": {"totalCount": 5}, "issues": {"totalCount": 7},
+  "primaryLanguage": {"name": "Go"},
+  "languages": {"edges": [{"size": 100, "node": {"name": "Go"}}, {"size": 10, "node": {"name": "Shell"}}]},
+  "repositoryTopics": {"nodes": [{"topic": {"name": "cli"}}]},
+  "owner": {"login": "octo", "avatarUrl": "https://avatars/octo", "url": "https://github.com/octo"},
+  "defaultBranchRef": {"name": "main", "branchProtectionRule": {"id": "r1"}, "target": {"committedDate": "2023-12-30T00:00:00Z"}}
+}`
+
+// emptyNode is a repository without commits: no language, license, push or
+// default branch.
+const emptyNode = `{
+  "databaseId": 2, "name": "empty", "nameWithOwner": "octo/empty",
+  "description": null, "isPrivate": false, "visibility": "PUBLIC",
+  "url": "https://github.com/octo/empty", "homepageUrl": null,
+  "licenseInfo": null, "createdAt": "2024-02-01T00:00:00Z",
+  "updatedAt": "2024-02-01T00:00:00Z", "pushedAt": null,
+  "viewerPermission": "ADMIN", "primaryLanguage": null,
+  "languages": {"edges": []}, "repositoryTopics": {"nodes": []},
+  "owner": {"login": "octo", "avatarUrl": "", "url": "https://github.com/octo"},
+  "defaultBranchRef": null
+}`
+
+func TestGraphQLRepositoriesPaging(t *testing.T) {
+	server := graphqlStandIn(t, [][]string{{fullNode}, {emptyNode}, {}})
+	defer server.Close()
+	client := newTestClient(t, server.URL, config.ListBackendGraphQL)
+
+	repos, err := client.ListAllRepositories(context.Background(), ListOptions{PerPage: 1})
+	if err != nil {
+		t.Fatalf("ListAllRepositories: %v", err)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("got %d repositories, want 2", len(repos))
+	}
+
+	full := repos[0]
+	if full.FullName != "octo/full" || full.ID != 1 || !full.Private || !full.IsTemplate {
+		t.Errorf("unexpected repository %+v", full)
+	}
+	if full.Visibility != "internal" || full.Homepage != "https://example.com" || !full.HasIssues || full.HasProjects || !full.HasWiki {
+		t.Errorf("unexpected settings %+v", full)
+	}
+	if full.Language != "Go" || full.Languages["Shell"] != 10 || len(full.Topics) != 1 || full.Topics[0] != "cli" {
+		t.Errorf("unexpected languages or topics %+v", full)
+	}
+	if full.License == nil || full.License.SPDXID != "MIT" {
+		t.Errorf("license = %+v, want MIT", full.License)
+	}
+	if full.DefaultBranch != "main" || full.DefaultBranchProtected == nil || !*full.DefaultBranchProtected || full.LastCommitAt != "2023-12-30T00:00:00Z" {
+		t.Errorf("unexpected default branch %+v", full)
+	}
+	if full.CloneURL != "https://github.com/octo/full.git" || full.StargazersCount != 3 || full.OpenIssuesCount != 7 || full.WatchersCount != 5 {
+		t.Errorf("unexpected counts or URLs %+v", full)
+	}
+	if p := full.Permissions; p == nil || p.Admin || p.Maintain || !p.Push || !p.Triage || !p.Pull {
+		t.Errorf("permissions = %+v, want write", p)
+	}
+
+	empty := repos[1]
+	if empty.Language != "" || empty.License != nil || empty.PushedAt != "" {
+		t.Errorf("null fields not mapped to zero values: %+v", empty)
+	}
+	if empty.Description != "" || empty.Homepage != "" || empty.Visibility != "public" {
+		t.Errorf("unexpected empty repository %+v", empty)
+	}
+	if empty.DefaultBranch != "" || empty.DefaultBranchProtected != nil || empty.LastCommitAt != "" {
+		t.Errorf("repository without commits has a default branch: %+v", empty)
+	}
+	if empty.Topics == nil || empty.Languages == nil {
+		t.Errorf("empty lists decoded as nil: %+v", empty)
+	}
+	if p := empty.Permissions; p == nil || !p.Admin {
+		t.Errorf("permissions = %+v, want admin", p)
+	}
+}
+
+func TestGraphQLHiddenBranchProtection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":{"owner":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[%s]}}},
+		  "errors":[{"type":"FORBIDDEN","message":"Resource not accessible","path":["owner","repositories","nodes",0,"defaultBranchRef","branchProtectionRule"]}]}`, fullNode)
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, config.ListBackendGraphQL)
+
+	repos, err := client.ListAllRepositories(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatalf("ListAllRepositories: %v", err)
+	}
+	if len(repos) != 1 || repos[0].DefaultBranchProtected != nil {
+		t.Errorf("protection the user can't see should be unknown, got %+v", repos)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := []struct {
+		name   string
+		status int
+		header map[string]string
+		body   string
+		want   error
+	}{
+		{
+			name: "rate limited",
+			body: `{"data":null,"errors":[{"type":"RATE_LIMITED","message":"API rate limit exceeded"}]}`,
+			want: ErrRateLimited,
+		},
+		{
+			name:   "rate limit exhausted",
+			status: http.StatusForbidden,
+			header: map[string]string{"X-RateLimit-Limit": "5000", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset, "X-RateLimit-Resource": "graphql"},
+			body:   `{"message":"API rate limit exceeded"}`,
+			want:   ErrRateLimited,
+		},
+		{
+			name: "forbidden",
+			body: `{"data":null,"errors":[{"type":"FORBIDDEN","message":"Resource not accessible"}]}`,
+			want: ErrForbidden,
+		},
+		{
+			name:   "unauthorized",
+			status: http.StatusUnauthorized,
+			body:   `{"message":"Bad credentials"}`,
+			want:   ErrUnauthorized,
+		},
+		{
+			name: "no owner",
+			body: `{"data":{"owner":null},"errors":[{"type":"NOT_FOUND","message":"Could not resolve to an Organization"}]}`,
+			want: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range tt.header {
+					w.Header().Set(name, value)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+			client := newTestClient(t, server.URL, config.ListBackendGraphQL)
+
+			_, err := client.OrganizationRepositories("octo", ListOptions{}).All(context.Background())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLRateLimitFailsFast(t *testing.T) {
+	requests := 0
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", reset)
+		w.Header().Set("X-RateLimit-Resource", "graphql")
+		fmt.Fprint(w, `{"data":{"owner":{"repositories":{"pageInfo":{"hasNextPage":true,"endCursor":"1"},"nodes":[]}}}}`)
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, config.ListBackendGraphQL)
+
+	// The first page uses up the budget; the second must not be sent.
+	_, err := client.ListAllRepositories(context.Background(), ListOptions{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrRateLimited || apiErr.RetryAfter <= 0 {
+		t.Fatalf("err = %v, want a rate limit error with a retry delay", err)
+	}
+	if requests != 1 {
+		t.Errorf("sent %d requests, want 1", requests)
+	}
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const defaultResponseCacheBytes = 32 << 20
+
+// cachedResponse is a GET response kept for revalidation.
+type cachedResponse struct {
+	key    string
+	header http.Header
+	body   []byte
+}
+
+func (r *cachedResponse) size() int {
+	return len(r.key) + len(r.body)
+}
+
+// ResponseCache is an LRU of GET responses bounded by their total size,
+// shared by the clients it is passed to. Entries are kept per token.
+type ResponseCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+// NewResponseCacheFromEnv returns a cache sized by GITHUB_CACHE_MAX_BYTES,
+// or nil if that is 0, which disables caching.
+func NewResponseCacheFromEnv() *ResponseCache {
+	maxBytes := defaultResponseCacheBytes
+	if value, err := strconv.Atoi(os.Getenv("GITHUB_CACHE_MAX_BYTES")); err == nil && value >= 0 {
+		maxBytes = value
+	}
+	if maxBytes == 0 {
+		return nil
+	}
+	return NewResponseCache(maxBytes)
+}
+
+// NewResponseCache returns a cache holding at most maxBytes of responses.
+func NewResponseCache(maxBytes int) *ResponseCache {
+	return &ResponseCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *ResponseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedResponse)
+}
+
+func (c *ResponseCache) put(response *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(response.key)
+	if response.size() > c.maxBytes {
+		return
+	}
+	c.entries[response.key] = c.order.PushFront(response)
+	c.bytes += response.size()
+	for c.bytes > c.maxBytes {
+		c.removeLocked(c.order.Back().Value.(*cachedResponse).key)
+	}
+}
+
+func (c *ResponseCache) removeLocked(key string) {
+	element, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.order.Remove(element)
+	delete(c.entries, key)
+	c.bytes -= element.Value.(*cachedResponse).size()
+}
+
+// conditionalTransport revalidates cached GET responses with If-None-Match
+// and If-Modified-Since. GitHub answers 304 Not Modified, which doesn't count
+// against the rate limit, and the cached body is served instead.
+type conditionalTransport struct {
+	base  http.RoundTripper
+	cache *ResponseCache
+	// token keeps users' responses apart: the same URL returns different
+	// data per user.
+	token string
+}
+
+// newConditionalTransport caches responses in cache, or returns base if it
+// is nil.
+func newConditionalTransport(base http.RoundTripper, cache *ResponseCache, accessToken string) http.RoundTripper {
+	if cache == nil {
+		return base
+	}
+	return &conditionalTransport{base: base, cache: cache, token: rateLimitKey(accessToken)}
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	key := t.token + " " + req.Header.Get("Accept") + " " + req.URL.String()
+	cached := t.cache.get(key)
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		// Headers sent with the 304, such as the rate limit, are newer
+		// than the cached ones.
+		header := cached.header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		t.cache.put(&cachedResponse{key: key, header: header, body: cached.body})
+		return cachedHTTPResponse(req, header, cached.body), nil
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.cache.put(&cachedResponse{key: key, header: resp.Header.Clone(), body: body})
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+	return resp, nil
+}
+
+func cachedHTTPResponse(req *http.Request, header http.Header, body []byte) *http.Response {
+	header = header.Clone()
+	header.Set("X-From-Cache", "1")
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// cachingClient returns a client for the stand-in API at apiURL that caches
+// in cache.
+func cachingClient(t *testing.T, apiURL, token string, cache *ResponseCache) *GitHubClient {
+	t.Helper()
+	t.Setenv("GITHUB_BASE_URL", "")
+	t.Setenv("GITHUB_API_URL", apiURL)
+	t.Setenv("GITHUB_MAX_RETRIES", "0")
+	return NewGitHubClient(&oauth2.Token{AccessToken: token}, &oauth2.Config{}, ClientOptions{Cache: cache})
+}
+
+func TestResponseCacheRevalidates(t *testing.T) {
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		w.Header().Set("X-RateLimit-Reset", "2000000000")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"id":1,"full_name":"octo/demo"}`)
+	}))
+	defer server.Close()
+	client := cachingClient(t, server.URL, "test-token", NewResponseCache(1<<20))
+
+	for i := 0; i < 2; i++ {
+		repo, err := client.GetRepository(context.Background(), "octo", "demo")
+		if err != nil {
+			t.Fatalf("GetRepository: %v", err)
+		}
+		if repo.FullName != "octo/demo" {
+			t.Errorf("request %d returned %+v", i, repo)
+		}
+	}
+	if len(conditional) != 2 || conditional[0] != "" || conditional[1] != `"v1"` {
+		t.Errorf("If-None-Match sent = %q, want none and then the ETag", conditional)
+	}
+	// GitHub doesn't count the 304; the budget it reported is kept.
+	if core := client.RateLimitStatus().Resources["core"]; core.Remaining != 4999 {
+		t.Errorf("remaining after the 304 = %d, want 4999", core.Remaining)
+	}
+}
+
+func TestResponseCacheIsPerToken(t *testing.T) {
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		owner := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-")
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintf(w, `{"id":1,"full_name":"%s/demo"}`, owner)
+	}))
+	defer server.Close()
+	cache := NewResponseCache(1 << 20)
+
+	for _, owner := range []string{"alice", "bob"} {
+		client := cachingClient(t, server.URL, "token-"+owner, cache)
+		repo, err := client.GetRepository(context.Background(), "octo", "demo")
+		if err != nil {
+			t.Fatalf("GetRepository: %v", err)
+		}
+		if repo.FullName != owner+"/demo" {
+			t.Errorf("%s got %s's response", owner, repo.FullName)
+		}
+	}
+	if len(conditional) != 2 || conditional[1] != "" {
+		t.Errorf("If-None-Match sent = %q, want no revalidation of another token's response", conditional)
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entry := func(key string) *cachedResponse {
+		return &cachedResponse{key: key, header: http.Header{}, body: make([]byte, 39)}
+	}
+	// Each entry is 40 bytes, so two fit.
+	cache := NewResponseCache(100)
+	cache.put(entry("a"))
+	cache.put(entry("b"))
+	if cache.get("a") == nil {
+		t.Fatal("a missing before the cache is full")
+	}
+	cache.put(entry("c"))
+
+	if cache.get("b") != nil {
+		t.Error("least recently used entry kept past maxBytes")
+	}
+	if cache.get("a") == nil || cache.get("c") == nil {
+		t.Error("recently used entries evicted")
+	}
+	if cache.bytes != 80 {
+		t.Errorf("cache holds %d bytes, want 80", cache.bytes)
+	}
+
+	cache.put(&cachedResponse{key: "big", header: http.Header{}, body: make([]byte, 200)})
+	if cache.get("big") != nil || cache.get("a") == nil {
+		t.Error("response larger than the cache was stored")
+	}
+}
+
+func TestResponseCacheFromEnv(t *testing.T) {
+	t.Setenv("GITHUB_CACHE_MAX_BYTES", "0")
+	if cache := NewResponseCacheFromEnv(); cache != nil {
+		t.Error("GITHUB_CACHE_MAX_BYTES=0 doesn't disable the cache")
+	}
+	t.Setenv("GITHUB_CACHE_MAX_BYTES", "1024")
+	if cache := NewResponseCacheFromEnv(); cache == nil || cache.maxBytes != 1024 {
+		t.Errorf("cache = %+v, want 1024 bytes", cache)
+	}
+}
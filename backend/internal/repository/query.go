@@ -0,0 +1,329 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a parsed repository search such as
+//
+//	language:go archived:false pushed:<2024-01-01 stars:>10 cli
+//
+// Qualifiers are ANDed together; a leading "-" negates one. Words without a
+// qualifier match the name or description.
+type Query struct {
+	filters []filter
+}
+
+type filter struct {
+	negate bool
+	match  func(repo Repository) bool
+}
+
+// ParseQuery parses the q parameter of the repository list endpoint.
+func ParseQuery(q string) (*Query, error) {
+	query := &Query{}
+	for _, token := range tokenize(q) {
+		negate := strings.HasPrefix(token, "-") && len(token) > 1
+		if negate {
+			token = token[1:]
+		}
+
+		key, value, isQualifier := strings.Cut(token, ":")
+		if !isQualifier {
+			text := strings.ToLower(token)
+			query.filters = append(query.filters, filter{negate: negate, match: func(repo Repository) bool {
+				return strings.Contains(strings.ToLower(repo.Name), text) ||
+					strings.Contains(strings.ToLower(repo.Description), text)
+			}})
+			continue
+		}
+
+		match, err := qualifier(strings.ToLower(key), value)
+		if err != nil {
+			return nil, err
+		}
+		query.filters = append(query.filters, filter{negate: negate, match: match})
+	}
+	return query, nil
+}
+
+// Match reports whether repo satisfies every term of the query.
+func (q *Query) Match(repo Repository) bool {
+	for _, f := range q.filters {
+		if f.match(repo) == f.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the repositories matching the query, keeping their order.
+func (q *Query) Filter(repos []Repository) []Repository {
+	matched := make([]Repository, 0, len(repos))
+	for _, repo := range repos {
+		if q.Match(repo) {
+			matched = append(matched, repo)
+		}
+	}
+	return matched
+}
+
+func qualifier(key, value string) (func(repo Repository) bool, error) {
+	if value == "" {
+		return nil, fmt.Errorf("qualifier %q has no value", key)
+	}
+
+	switch key {
+	case "language":
+		return func(repo Repository) bool { return strings.EqualFold(repo.Language, value) }, nil
+	case "user", "owner":
+		return func(repo Repository) bool { return strings.EqualFold(repo.Owner.Login, value) }, nil
+	case "name":
+		return func(repo Repository) bool {
+			return strings.Contains(strings.ToLower(repo.Name), strings.ToLower(value))
+		}, nil
+	case "visibility":
+		switch visibility := strings.ToLower(value); visibility {
+		case "public", "private", "internal":
+			return func(repo Repository) bool { return visibilityOf(repo) == visibility }, nil
+		}
+		return nil, fmt.Errorf("visibility must be public, private or internal, got %q", value)
+	case "is":
+		switch is := strings.ToLower(value); is {
+		case "public", "private", "internal":
+			return func(repo Repository) bool { return visibilityOf(repo) == is }, nil
+		case "archived":
+			return func(repo Repository) bool { return repo.Archived }, nil
+		case "fork":
+			return func(repo Repository) bool { return repo.Fork }, nil
+		}
+		return nil, fmt.Errorf("unknown is: value %q", value)
+	case "archived", "fork":
+		want, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, got %q", key, value)
+		}
+		if key == "archived" {
+			return func(repo Repository) bool { return repo.Archived == want }, nil
+		}
+		return func(repo Repository) bool { return repo.Fork == want }, nil
+	case "stars", "forks", "size", "issues", "watchers":
+		cmp, err := parseNumberRange(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s qualifier: %w", key, err)
+		}
+		field := numberFields[key]
+		return func(repo Repository) bool { return cmp(field(repo)) }, nil
+	case "pushed", "created", "updated":
+		cmp, err := parseDateRange(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s qualifier: %w", key, err)
+		}
+		field := dateFields[key]
+		return func(repo Repository) bool {
+			t, err := time.Parse(time.RFC3339, field(repo))
+			return err == nil && cmp(t)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown qualifier %q", key)
+}
+
+// visibilityOf returns the repository's visibility, falling back to its
+// private flag for repositories cached before visibility was recorded.
+// Internal repositories are private too, so the flag alone can't tell them
+// apart.
+func visibilityOf(repo Repository) string {
+	if repo.Visibility != "" {
+		return strings.ToLower(repo.Visibility)
+	}
+	if repo.Private {
+		return "private"
+	}
+	return "public"
+}
+
+var numberFields = map[string]func(Repository) int{
+	"stars":    func(r Repository) int { return r.StargazersCount },
+	"forks":    func(r Repository) int { return r.ForksCount },
+	"size":     func(r Repository) int { return r.Size },
+	"issues":   func(r Repository) int { return r.OpenIssuesCount },
+	"watchers": func(r Repository) int { return r.WatchersCount },
+}
+
+var dateFields = map[string]func(Repository) string{
+	"pushed":  func(r Repository) string { return r.PushedAt },
+	"created": func(r Repository) string { return r.CreatedAt },
+	"updated": func(r Repository) string { return r.UpdatedAt },
+}
+
+// parseNumberRange accepts N, >N, >=N, <N, <=N and N..M.
+func parseNumberRange(value string) (func(int) bool, error) {
+	if low, high, ok := strings.Cut(value, ".."); ok {
+		lo, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := strconv.Atoi(high)
+		if err != nil {
+			return nil, err
+		}
+		return func(n int) bool { return n >= lo && n <= hi }, nil
+	}
+
+	op, operand := splitOperator(value)
+	n, err := strconv.Atoi(operand)
+	if err != nil {
+		return nil, err
+	}
+	return func(v int) bool { return compare(op, v-n) }, nil
+}
+
+// parseDateRange accepts the same operators as parseNumberRange with
+// YYYY-MM-DD or RFC 3339 operands. A bare date matches that whole day.
+func parseDateRange(value string) (func(time.Time) bool, error) {
+	if low, high, ok := strings.Cut(value, ".."); ok {
+		lo, _, err := parseDate(low)
+		if err != nil {
+			return nil, err
+		}
+		_, hi, err := parseDate(high)
+		if err != nil {
+			return nil, err
+		}
+		return func(t time.Time) bool { return !t.Before(lo) && t.Before(hi) }, nil
+	}
+
+	op, operand := splitOperator(value)
+	start, end, err := parseDate(operand)
+	if err != nil {
+		return nil, err
+	}
+	return func(t time.Time) bool {
+		switch op {
+		case ">":
+			return !t.Before(end)
+		case ">=":
+			return !t.Before(start)
+		case "<":
+			return t.Before(start)
+		case "<=":
+			return t.Before(end)
+		}
+		return !t.Before(start) && t.Before(end)
+	}, nil
+}
+
+// parseDate returns the half-open interval [start, end) a date denotes.
+func parseDate(value string) (time.Time, time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, t.Add(time.Second), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return t, t.AddDate(0, 0, 1), nil
+}
+
+func splitOperator(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(value, op) {
+			return op, strings.TrimPrefix(value, op)
+		}
+	}
+	return "=", value
+}
+
+func compare(op string, diff int) bool {
+	switch op {
+	case ">":
+		return diff > 0
+	case ">=":
+		return diff >= 0
+	case "<":
+		return diff < 0
+	case "<=":
+		return diff <= 0
+	}
+	return diff == 0
+}
+
+// tokenize splits on whitespace, keeping double-quoted parts together so
+// that name:"my project" is a single token.
+func tokenize(q string) []string {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+var sortKeys = map[string]func(a, b Repository) int{
+	"name":      func(a, b Repository) int { return compareFold(a.Name, b.Name) },
+	"full_name": func(a, b Repository) int { return compareFold(a.FullName, b.FullName) },
+	"language":  func(a, b Repository) int { return compareFold(a.Language, b.Language) },
+	"stars":     func(a, b Repository) int { return a.StargazersCount - b.StargazersCount },
+	"forks":     func(a, b Repository) int { return a.ForksCount - b.ForksCount },
+	"size":      func(a, b Repository) int { return a.Size - b.Size },
+	"issues":    func(a, b Repository) int { return a.OpenIssuesCount - b.OpenIssuesCount },
+	"created":   func(a, b Repository) int { return strings.Compare(a.CreatedAt, b.CreatedAt) },
+	"updated":   func(a, b Repository) int { return strings.Compare(a.UpdatedAt, b.UpdatedAt) },
+	"pushed":    func(a, b Repository) int { return strings.Compare(a.PushedAt, b.PushedAt) },
+}
+
+func compareFold(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// SortRepositories sorts repos in place by key. Ties are broken by ID so the
+// order, and therefore pagination, is stable across requests.
+func SortRepositories(repos []Repository, key string, descending bool) error {
+	cmp, ok := sortKeys[key]
+	if !ok {
+		return fmt.Errorf("unknown sort key %q", key)
+	}
+
+	sort.SliceStable(repos, func(i, j int) bool {
+		if c := cmp(repos[i], repos[j]); c != 0 {
+			if descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return repos[i].ID < repos[j].ID
+	})
+	return nil
+}
+
+// Paginate returns the given 1-based page of repos, which is empty past the
+// end.
+func Paginate(repos []Repository, page, perPage int) []Repository {
+	start := (page - 1) * perPage
+	if start < 0 || start >= len(repos) {
+		return []Repository{}
+	}
+	end := start + perPage
+	if end > len(repos) {
+		end = len(repos)
+	}
+	return repos[start:end]
+}
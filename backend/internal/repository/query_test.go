@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var queryRepos = []Repository{
+	{ID: 1, Name: "cli", FullName: "octo/cli", Description: "Command line tool", Language: "Go", Owner: Owner{Login: "octo"}, Visibility: "public", StargazersCount: 50, ForksCount: 3, Size: 900, PushedAt: "2024-03-01T10:00:00Z", CreatedAt: "2020-01-01T00:00:00Z", UpdatedAt: "2024-03-02T00:00:00Z"},
+	{ID: 2, Name: "secret", FullName: "octo/secret", Language: "Python", Owner: Owner{Login: "octo"}, Private: true, Visibility: "private", StargazersCount: 5, ForksCount: 10, Size: 100, Archived: true, PushedAt: "2023-06-15T12:00:00Z", CreatedAt: "2021-01-01T00:00:00Z", UpdatedAt: "2023-06-15T12:00:00Z"},
+	{ID: 3, Name: "Handbook", FullName: "corp/Handbook", Description: "Internal docs", Owner: Owner{Login: "corp"}, Private: true, Visibility: "internal", StargazersCount: 10, ForksCount: 0, Size: 300, Fork: true, PushedAt: "2024-01-01T00:00:00Z", CreatedAt: "2022-01-01T00:00:00Z", UpdatedAt: "2024-01-01T00:00:00Z"},
+	// Cached before visibility was recorded.
+	{ID: 4, Name: "legacy", FullName: "corp/legacy", Language: "go", Owner: Owner{Login: "corp"}, Private: true, StargazersCount: 10, ForksCount: 1, Size: 300, PushedAt: "2024-03-01T23:59:59Z", CreatedAt: "2019-01-01T00:00:00Z", UpdatedAt: "2024-03-01T23:59:59Z"},
+}
+
+func matchedIDs(t *testing.T, q string) []int {
+	t.Helper()
+	query, err := ParseQuery(q)
+	if err != nil {
+		t.Fatalf("ParseQuery(%q) = %v", q, err)
+	}
+	ids := []int{}
+	for _, repo := range query.Filter(queryRepos) {
+		ids = append(ids, repo.ID)
+	}
+	return ids
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []int
+	}{
+		{q: "", want: []int{1, 2, 3, 4}},
+		{q: "language:go", want: []int{1, 4}},
+		{q: "-language:go", want: []int{2, 3}},
+		{q: "user:corp", want: []int{3, 4}},
+		{q: "owner:OCTO", want: []int{1, 2}},
+		{q: "name:hand", want: []int{3}},
+		{q: `name:"hand book"`, want: []int{}},
+		{q: "docs", want: []int{3}},
+		{q: "-docs command", want: []int{1}},
+		{q: "visibility:public", want: []int{1}},
+		{q: "visibility:private", want: []int{2, 4}},
+		{q: "visibility:internal", want: []int{3}},
+		{q: "is:internal", want: []int{3}},
+		{q: "-is:public", want: []int{2, 3, 4}},
+		{q: "is:archived", want: []int{2}},
+		{q: "is:fork", want: []int{3}},
+		{q: "archived:false fork:false", want: []int{1, 4}},
+		{q: "stars:10", want: []int{3, 4}},
+		{q: "stars:>10", want: []int{1}},
+		{q: "stars:>=10", want: []int{1, 3, 4}},
+		{q: "stars:<10", want: []int{2}},
+		{q: "forks:1..3", want: []int{1, 4}},
+		{q: "size:<=300", want: []int{2, 3, 4}},
+		{q: "pushed:2024-03-01", want: []int{1, 4}},
+		{q: "pushed:>2024-01-01", want: []int{1, 4}},
+		{q: "pushed:>=2024-01-01", want: []int{1, 3, 4}},
+		{q: "pushed:<2024-01-01", want: []int{2}},
+		{q: "created:2020-01-01..2021-01-01", want: []int{1, 2}},
+		{q: "updated:2024-03-02T00:00:00Z", want: []int{1}},
+		{q: "language:go stars:>20", want: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			if got := matchedIDs(t, tt.q); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{q: "language:", want: "has no value"},
+		{q: "visibility:secret", want: "visibility must be public, private or internal"},
+		{q: "is:starred", want: "unknown is: value"},
+		{q: "archived:maybe", want: "archived must be true or false"},
+		{q: "stars:many", want: "invalid stars qualifier"},
+		{q: "forks:1..x", want: "invalid forks qualifier"},
+		{q: "pushed:yesterday", want: "invalid pushed qualifier"},
+		{q: "created:2024-01-01..soon", want: "invalid created qualifier"},
+		{q: "topic:go", want: `unknown qualifier "topic"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			_, err := ParseQuery(tt.q)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseQuery(%q) = %v, want %q", tt.q, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortRepositories(t *testing.T) {
+	tests := []struct {
+		key  string
+		asc  []int
+		desc []int
+	}{
+		{key: "name", asc: []int{1, 3, 4, 2}, desc: []int{2, 4, 3, 1}},
+		{key: "full_name", asc: []int{3, 4, 1, 2}, desc: []int{2, 1, 4, 3}},
+		{key: "language", asc: []int{3, 1, 4, 2}, desc: []int{2, 1, 4, 3}},
+		// Ties keep ID order in both directions.
+		{key: "stars", asc: []int{2, 3, 4, 1}, desc: []int{1, 3, 4, 2}},
+		{key: "forks", asc: []int{3, 4, 1, 2}, desc: []int{2, 1, 4, 3}},
+		{key: "size", asc: []int{2, 3, 4, 1}, desc: []int{1, 3, 4, 2}},
+		{key: "issues", asc: []int{1, 2, 3, 4}, desc: []int{1, 2, 3, 4}},
+		{key: "created", asc: []int{4, 1, 2, 3}, desc: []int{3, 2, 1, 4}},
+		{key: "updated", asc: []int{2, 3, 4, 1}, desc: []int{1, 4, 3, 2}},
+		{key: "pushed", asc: []int{2, 3, 1, 4}, desc: []int{4, 1, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		for _, descending := range []bool{false, true} {
+			want := tt.asc
+			if descending {
+				want = tt.desc
+			}
+			repos := append([]Repository(nil), queryRepos...)
+			if err := SortRepositories(repos, tt.key, descending); err != nil {
+				t.Fatalf("SortRepositories(%s) = %v", tt.key, err)
+			}
+			got := []int{}
+			for _, repo := range repos {
+				got = append(got, repo.ID)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("SortRepositories(%s, descending %t) = %v, want %v", tt.key, descending, got, want)
+			}
+		}
+	}
+
+	if err := SortRepositories(queryRepos, "popularity", false); err == nil {
+		t.Error("SortRepositories with an unknown key succeeded")
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		page, perPage int
+		want          []int
+	}{
+		{page: 1, perPage: 3, want: []int{1, 2, 3}},
+		{page: 2, perPage: 3, want: []int{4}},
+		{page: 3, perPage: 3, want: []int{}},
+		{page: 1, perPage: 10, want: []int{1, 2, 3, 4}},
+		{page: 2, perPage: 2, want: []int{3, 4}},
+		{page: 0, perPage: 2, want: []int{}},
+	}
+
+	for _, tt := range tests {
+		got := []int{}
+		for _, repo := range Paginate(queryRepos, tt.page, tt.perPage) {
+			got = append(got, repo.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Paginate(%d, %d) = %v, want %v", tt.page, tt.perPage, got, tt.want)
+		}
+	}
+}
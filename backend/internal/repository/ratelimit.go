@@ -0,0 +1,323 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = time.Second
+	defaultMaxWait        = time.Minute
+	// rateLimitStateTTL is how long the state of a token nobody uses any
+	// more is kept.
+	rateLimitStateTTL = 24 * time.Hour
+)
+
+// SecondaryRateLimitPause is how long to hold back requests after a
+// secondary rate limit response without a Retry-After header, the minimum
+// GitHub asks for.
+const SecondaryRateLimitPause = time.Minute
+
+// RateLimit is the budget of one GitHub rate limit resource ("core",
+// "search", "graphql", ...) as of the last response that reported it.
+type RateLimit struct {
+	Resource  string    `json:"resource"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Used      int       `json:"used"`
+	Reset     time.Time `json:"reset"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RateLimitStatus is everything known about a token's rate limits.
+// PausedUntil is set while requests are held back after a secondary limit.
+type RateLimitStatus struct {
+	Resources   map[string]RateLimit `json:"resources"`
+	PausedUntil *time.Time           `json:"paused_until,omitempty"`
+}
+
+type rateLimitState struct {
+	resources   map[string]RateLimit
+	pausedUntil time.Time
+	seenAt      time.Time
+}
+
+// RateLimitTracker keeps the rate limit state of every token. Clients of
+// the same token must share one, so each knows about the others' requests.
+type RateLimitTracker struct {
+	mu     sync.Mutex
+	tokens map[string]*rateLimitState
+}
+
+func NewRateLimitTracker() *RateLimitTracker {
+	return &RateLimitTracker{tokens: make(map[string]*rateLimitState)}
+}
+
+// stateLocked returns the state for key, creating it if needed.
+func (t *RateLimitTracker) stateLocked(key string) *rateLimitState {
+	now := time.Now()
+	state, ok := t.tokens[key]
+	if !ok {
+		for other, s := range t.tokens {
+			if now.Sub(s.seenAt) > rateLimitStateTTL {
+				delete(t.tokens, other)
+			}
+		}
+		state = &rateLimitState{resources: make(map[string]RateLimit)}
+		t.tokens[key] = state
+	}
+	state.seenAt = now
+	return state
+}
+
+// observe records the X-RateLimit-* headers of a response.
+func (t *RateLimitTracker) observe(key string, header http.Header) {
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	used, _ := strconv.Atoi(header.Get("X-RateLimit-Used"))
+	reset, _ := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	resource := header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+
+	t.set(key, RateLimit{
+		Resource:  resource,
+		Limit:     limit,
+		Remaining: remaining,
+		Used:      used,
+		Reset:     time.Unix(reset, 0),
+		UpdatedAt: time.Now(),
+	})
+}
+
+func (t *RateLimitTracker) set(key string, limit RateLimit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stateLocked(key).resources[limit.Resource] = limit
+}
+
+// pause holds back every request with key for d.
+func (t *RateLimitTracker) pause(key string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := t.stateLocked(key)
+	if until := time.Now().Add(d); until.After(state.pausedUntil) {
+		state.pausedUntil = until
+	}
+}
+
+// wait returns how long a request for resource must wait: until a pause
+// ends, or until an exhausted budget resets.
+func (t *RateLimitTracker) wait(key, resource string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.tokens[key]
+	if !ok {
+		return 0
+	}
+	until := state.pausedUntil
+	if limit, ok := state.resources[resource]; ok && limit.Remaining == 0 && limit.Reset.After(until) {
+		until = limit.Reset
+	}
+	return time.Until(until)
+}
+
+func (t *RateLimitTracker) status(key string) RateLimitStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := RateLimitStatus{Resources: make(map[string]RateLimit)}
+	state, ok := t.tokens[key]
+	if !ok {
+		return status
+	}
+	for name, limit := range state.resources {
+		status.Resources[name] = limit
+	}
+	if state.pausedUntil.After(time.Now()) {
+		pausedUntil := state.pausedUntil
+		status.PausedUntil = &pausedUntil
+	}
+	return status
+}
+
+// rateLimitKey identifies a token in the tracker without keeping the token
+// itself around.
+func rateLimitKey(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// rateLimitTransport tracks the rate limit of one token. It waits instead of
+// sending requests bound to be rejected, and retries 5xx responses and
+// secondary rate limits with jittered exponential backoff.
+type rateLimitTransport struct {
+	base       http.RoundTripper
+	limits     *RateLimitTracker
+	key        string
+	maxRetries int
+	baseDelay  time.Duration
+	// maxWait is the longest a request is held back. Longer waits fail with
+	// ErrRateLimited instead, which pauses bulk jobs.
+	maxWait time.Duration
+}
+
+// newRateLimitTransport reads GITHUB_MAX_RETRIES, GITHUB_RETRY_BASE_DELAY and
+// GITHUB_RATE_LIMIT_MAX_WAIT.
+func newRateLimitTransport(base http.RoundTripper, limits *RateLimitTracker, accessToken string) *rateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &rateLimitTransport{
+		base:       base,
+		limits:     limits,
+		key:        rateLimitKey(accessToken),
+		maxRetries: defaultMaxRetries,
+		baseDelay:  defaultRetryBaseDelay,
+		maxWait:    defaultMaxWait,
+	}
+	if value, err := strconv.Atoi(os.Getenv("GITHUB_MAX_RETRIES")); err == nil && value >= 0 {
+		t.maxRetries = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("GITHUB_RETRY_BASE_DELAY")); err == nil && value > 0 {
+		t.baseDelay = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("GITHUB_RATE_LIMIT_MAX_WAIT")); err == nil && value >= 0 {
+		t.maxWait = value
+	}
+	return t
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := resourceFor(req.URL.Path)
+	for attempt := 0; ; attempt++ {
+		if wait := t.limits.wait(t.key, resource); wait > 0 && resource != "" {
+			if wait > t.maxWait {
+				return nil, &APIError{
+					StatusCode: http.StatusTooManyRequests,
+					Message:    fmt.Sprintf("GitHub rate limit exhausted until %s", time.Now().Add(wait).Format(time.RFC3339)),
+					Kind:       ErrRateLimited,
+					RetryAfter: wait,
+				}
+			}
+			if err := sleep(req.Context(), wait); err != nil {
+				return nil, err
+			}
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+		t.limits.observe(t.key, resp.Header)
+
+		delay, retry := t.retryDelay(req, resp, attempt)
+		if !retry {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+
+		log.Printf("GitHub returned %d for %s %s, retrying in %s (attempt %d of %d)", resp.StatusCode, req.Method, req.URL.Path, delay.Round(time.Millisecond), attempt+1, t.maxRetries)
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelay decides whether a response is worth retrying and after how
+// long. Rate limits pause every request with the token, retried or not.
+func (t *rateLimitTransport) retryDelay(req *http.Request, resp *http.Response, attempt int) (time.Duration, bool) {
+	retryable := attempt < t.maxRetries && (req.Body == nil || req.GetBody != nil)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusForbidden:
+		if resp.StatusCode == http.StatusForbidden && !isRateLimitResponse(resp, peekMessage(resp)) {
+			return 0, false
+		}
+		delay := retryAfter(resp)
+		if delay == 0 {
+			delay = t.backoff(attempt)
+			if resp.Header.Get("X-RateLimit-Remaining") != "0" && delay < SecondaryRateLimitPause {
+				delay = SecondaryRateLimitPause
+			}
+		}
+		t.limits.pause(t.key, delay)
+		return delay, retryable && delay <= t.maxWait
+	case resp.StatusCode >= 500 && (req.Method != http.MethodPost || resourceFor(req.URL.Path) == "graphql"):
+		// POST isn't idempotent: the request may have been carried out.
+		// GraphQL is only used for queries.
+		return t.backoff(attempt), retryable
+	}
+	return 0, false
+}
+
+// backoff is baseDelay doubled per attempt, with jitter so clients that
+// failed together don't retry together.
+func (t *rateLimitTransport) backoff(attempt int) time.Duration {
+	delay := t.baseDelay << attempt
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// peekMessage reads the error message of a response, leaving the body in
+// place for newAPIError.
+func peekMessage(resp *http.Response) string {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return string(data)
+}
+
+// resourceFor guesses which rate limit a request counts against. The
+// rate_limit endpoint itself is free.
+func resourceFor(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/rate_limit"), strings.HasSuffix(path, "/rate_limit"):
+		return ""
+	case strings.Contains(path, "/search/"):
+		return "search"
+	case strings.HasSuffix(path, "/graphql"):
+		return "graphql"
+	}
+	return "core"
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// retryingClient returns a client for the stand-in API at apiURL that
+// retries up to retries times, almost without delay between attempts.
+func retryingClient(t *testing.T, apiURL, token string, retries int, limits *RateLimitTracker) *GitHubClient {
+	t.Helper()
+	t.Setenv("GITHUB_BASE_URL", "")
+	t.Setenv("GITHUB_API_URL", apiURL)
+	t.Setenv("GITHUB_MAX_RETRIES", strconv.Itoa(retries))
+	t.Setenv("GITHUB_RETRY_BASE_DELAY", "1ms")
+	t.Setenv("GITHUB_RATE_LIMIT_MAX_WAIT", "5s")
+	return NewGitHubClient(&oauth2.Token{AccessToken: token}, &oauth2.Config{}, ClientOptions{RateLimits: limits})
+}
+
+func TestRetryDelay(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(30*time.Second).Unix(), 10)
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		header  map[string]string
+		body    string
+		attempt int
+		retry   bool
+		min     time.Duration
+		max     time.Duration
+		paused  bool
+	}{
+		{
+			name:   "retry after",
+			status: http.StatusForbidden,
+			header: map[string]string{"Retry-After": "20"},
+			body:   `{"message":"You have exceeded a secondary rate limit"}`,
+			retry:  true, min: 20 * time.Second, max: 20 * time.Second, paused: true,
+		},
+		{
+			name:   "secondary limit without retry after",
+			status: http.StatusForbidden,
+			header: map[string]string{"X-RateLimit-Remaining": "12"},
+			body:   `{"message":"You have exceeded a secondary rate limit"}`,
+			retry:  true, min: SecondaryRateLimitPause, max: SecondaryRateLimitPause, paused: true,
+		},
+		{
+			name:   "exhausted until reset",
+			status: http.StatusTooManyRequests,
+			header: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset},
+			retry:  true, min: 28 * time.Second, max: 30 * time.Second, paused: true,
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+			body:   `{"message":"Resource not accessible by integration"}`,
+		},
+		{
+			name:   "longer than the maximum wait",
+			status: http.StatusForbidden,
+			header: map[string]string{"Retry-After": "3600"},
+			min:    time.Hour, max: time.Hour, paused: true,
+		},
+		{
+			name:    "rate limit after the last retry",
+			status:  http.StatusTooManyRequests,
+			header:  map[string]string{"Retry-After": "20"},
+			attempt: 2,
+			min:     20 * time.Second, max: 20 * time.Second, paused: true,
+		},
+		{name: "server error", status: http.StatusBadGateway, retry: true, min: 500 * time.Microsecond, max: time.Millisecond},
+		{name: "server error on the last retry", status: http.StatusBadGateway, attempt: 2, min: 2 * time.Millisecond, max: 4 * time.Millisecond},
+		{name: "server error after a POST", method: http.MethodPost, status: http.StatusBadGateway},
+		{name: "server error after a GraphQL query", method: http.MethodPost, path: "/graphql", status: http.StatusBadGateway, retry: true, min: 500 * time.Microsecond, max: time.Millisecond},
+		{name: "not found", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := NewRateLimitTracker()
+			transport := &rateLimitTransport{limits: limits, key: "key", maxRetries: 2, baseDelay: time.Millisecond, maxWait: time.Minute}
+			method, path := tt.method, tt.path
+			if method == "" {
+				method = http.MethodGet
+			}
+			if path == "" {
+				path = "/repos/octo/demo"
+			}
+			req, err := http.NewRequest(method, "https://api.github.com"+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: http.NoBody}
+			for name, value := range tt.header {
+				resp.Header.Set(name, value)
+			}
+			if tt.body != "" {
+				resp.Body = io.NopCloser(strings.NewReader(tt.body))
+			}
+
+			delay, retry := transport.retryDelay(req, resp, tt.attempt)
+			if retry != tt.retry {
+				t.Errorf("retry = %t, want %t", retry, tt.retry)
+			}
+			if tt.max > 0 && (delay < tt.min || delay > tt.max) {
+				t.Errorf("delay = %s, want %s to %s", delay, tt.min, tt.max)
+			}
+			if paused := limits.status("key").PausedUntil != nil; paused != tt.paused {
+				t.Errorf("paused = %t, want %t", paused, tt.paused)
+			}
+		})
+	}
+}
+
+func TestRetriesAreCapped(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	client := retryingClient(t, server.URL, "test-token", 2, nil)
+
+	_, err := client.GetRepository(context.Background(), "octo", "demo")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err = %v, want the last 502", err)
+	}
+	if requests != 3 {
+		t.Errorf("sent %d requests, want 1 and 2 retries", requests)
+	}
+}
+
+func TestSecondaryRateLimitIsRetriedAfterRetryAfter(t *testing.T) {
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message":"You have exceeded a secondary rate limit"}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":1,"full_name":"octo/demo"}`)
+	}))
+	defer server.Close()
+	client := retryingClient(t, server.URL, "test-token", 1, nil)
+
+	if _, err := client.GetRepository(context.Background(), "octo", "demo"); err != nil {
+		t.Fatalf("GetRepository: %v", err)
+	}
+	if len(times) != 2 || times[1].Sub(times[0]) < time.Second {
+		t.Errorf("retried %d times, %s after the rate limit", len(times)-1, times[len(times)-1].Sub(times[0]))
+	}
+}
+
+func TestExhaustedBudgetWaitsForReset(t *testing.T) {
+	var reset time.Time
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			reset = time.Now().Add(time.Second).Truncate(time.Second).Add(time.Second)
+			w.Header().Set("X-RateLimit-Limit", "5000")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		}
+		fmt.Fprint(w, `{"id":1,"full_name":"octo/demo"}`)
+	}))
+	defer server.Close()
+	client := retryingClient(t, server.URL, "test-token", 0, nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetRepository(context.Background(), "octo", "demo"); err != nil {
+			t.Fatalf("GetRepository: %v", err)
+		}
+	}
+	if len(times) != 2 || times[1].Before(reset) {
+		t.Errorf("second request sent at %s, before the reset at %s", times[1], reset)
+	}
+}
+
+func TestRateLimitStatusIsPerToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rate_limit" {
+			fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":4000,"used":1000,"reset":2000000000}}}`)
+			return
+		}
+		if r.Header.Get("Authorization") == "Bearer token-limited" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message":"You have exceeded a secondary rate limit"}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":1,"full_name":"octo/demo"}`)
+	}))
+	defer server.Close()
+	limits := NewRateLimitTracker()
+	limited := retryingClient(t, server.URL, "token-limited", 0, limits)
+	other := retryingClient(t, server.URL, "token-other", 0, limits)
+
+	if _, err := limited.GetRepository(context.Background(), "octo", "demo"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want %v", err, ErrRateLimited)
+	}
+	if _, err := other.GetRepository(context.Background(), "octo", "demo"); err != nil {
+		t.Fatalf("other token held back: %v", err)
+	}
+
+	// What /api/rate-limit reports.
+	status, err := limited.RefreshRateLimit(context.Background())
+	if err != nil {
+		t.Fatalf("RefreshRateLimit: %v", err)
+	}
+	if status.PausedUntil == nil || time.Until(*status.PausedUntil) < 25*time.Second {
+		t.Errorf("paused until %v, want about 30s from now", status.PausedUntil)
+	}
+	if core := status.Resources["core"]; core.Remaining != 4000 || core.Limit != 5000 {
+		t.Errorf("core = %+v", core)
+	}
+	if status, err := other.RefreshRateLimit(context.Background()); err != nil || status.PausedUntil != nil {
+		t.Errorf("other token's status = %+v, %v, want it not paused", status, err)
+	}
+
+	// The paused token fails fast instead of sending more requests.
+	t.Setenv("GITHUB_RATE_LIMIT_MAX_WAIT", "1s")
+	fastFail := NewGitHubClient(&oauth2.Token{AccessToken: "token-limited"}, &oauth2.Config{}, ClientOptions{RateLimits: limits})
+	var apiErr *APIError
+	if _, err := fastFail.GetRepository(context.Background(), "octo", "demo"); !errors.As(err, &apiErr) || apiErr.Kind != ErrRateLimited || apiErr.RetryAfter < 25*time.Second {
+		t.Errorf("err = %v, want a rate limit error retrying after the pause", err)
+	}
+}
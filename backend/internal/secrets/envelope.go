@@ -0,0 +1,166 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Envelope versions. v2 ciphertexts are bound to associated data, e.g. the
+// ID of the user a token belongs to, so a ciphertext copied to another
+// record doesn't decrypt. v1 envelopes, written before, aren't.
+const (
+	envelopeV1 = "v1"
+	envelopeV2 = "v2"
+)
+
+// Envelope is a value encrypted with its own random data key. The data key is
+// stored wrapped by the master key identified by KeyID, so rotating the
+// master key only requires re-wrapping the data key.
+type Envelope struct {
+	Version    string
+	KeyID      string
+	WrappedKey []byte
+	Ciphertext []byte
+}
+
+// Bound reports whether the envelope's ciphertext is bound to associated
+// data. Unbound envelopes should be sealed again.
+func (e *Envelope) Bound() bool {
+	return e.Version != envelopeV1
+}
+
+// Seal encrypts plaintext with a fresh data key wrapped by the active master
+// key. The same associated data must be given to Open.
+func (k *Keyring) Seal(plaintext, associatedData []byte) (*Envelope, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	ciphertext, err := gcmSeal(dataKey, plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := gcmSeal(k.keys[k.activeID], dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{Version: envelopeV2, KeyID: k.activeID, WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts an envelope with whichever master key it was wrapped with.
+// It fails if associatedData isn't what the envelope was sealed with;
+// unbound envelopes ignore it.
+func (k *Keyring) Open(envelope *Envelope, associatedData []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(envelope)
+	if err != nil {
+		return nil, err
+	}
+	if !envelope.Bound() {
+		associatedData = nil
+	}
+	return gcmOpen(dataKey, envelope.Ciphertext, associatedData)
+}
+
+// Rewrap re-encrypts the envelope's data key with the active master key,
+// leaving the ciphertext untouched.
+func (k *Keyring) Rewrap(envelope *Envelope) (*Envelope, error) {
+	dataKey, err := k.unwrap(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := gcmSeal(k.keys[k.activeID], dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{Version: envelope.Version, KeyID: k.activeID, WrappedKey: wrapped, Ciphertext: envelope.Ciphertext}, nil
+}
+
+func (k *Keyring) unwrap(envelope *Envelope) ([]byte, error) {
+	masterKey, err := k.key(envelope.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := gcmOpen(masterKey, envelope.WrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// String encodes the envelope as "<version>.<key id>.<wrapped key>.<ciphertext>".
+func (e *Envelope) String() string {
+	return strings.Join([]string{
+		e.Version,
+		e.KeyID,
+		base64.RawURLEncoding.EncodeToString(e.WrappedKey),
+		base64.RawURLEncoding.EncodeToString(e.Ciphertext),
+	}, ".")
+}
+
+// IsEnvelope reports whether value looks like an encoded envelope.
+func IsEnvelope(value string) bool {
+	return strings.HasPrefix(value, envelopeV1+".") || strings.HasPrefix(value, envelopeV2+".")
+}
+
+func ParseEnvelope(value string) (*Envelope, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 || (parts[0] != envelopeV1 && parts[0] != envelopeV2) {
+		return nil, fmt.Errorf("malformed envelope")
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed envelope key: %w", err)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("malformed envelope ciphertext: %w", err)
+	}
+
+	return &Envelope{Version: parts[0], KeyID: parts[1], WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// gcmSeal encrypts with AES-GCM and prefixes the random nonce.
+func gcmSeal(key, plaintext, associatedData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func gcmOpen(key, sealed, associatedData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, associatedData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
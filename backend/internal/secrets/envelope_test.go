@@ -0,0 +1,165 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, masterKeySize)
+}
+
+// testKeyring returns a keyring of the given keys, each derived from its ID
+// so that keyrings sharing an ID share the key.
+func testKeyring(t *testing.T, activeID string, ids ...string) *Keyring {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = testKey(id[len(id)-1])
+	}
+	keyring, err := NewKeyring(keys, activeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	keyring := testKeyring(t, "k1", "k1")
+	envelope, err := keyring.Seal([]byte("gho_secret"), []byte("user_token:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEnvelope(envelope.String()) {
+		t.Fatalf("IsEnvelope(%q) = false", envelope.String())
+	}
+
+	parsed, err := ParseEnvelope(envelope.String())
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+	plaintext, err := keyring.Open(parsed, []byte("user_token:1"))
+	if err != nil || string(plaintext) != "gho_secret" {
+		t.Errorf("Open = %q, %v", plaintext, err)
+	}
+}
+
+func TestEnvelopeKeyRotation(t *testing.T) {
+	old := testKeyring(t, "k1", "k1")
+	envelope, err := old.Seal([]byte("gho_secret"), []byte("user_token:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := testKeyring(t, "k2", "k1", "k2")
+	// Values sealed with the old key still open.
+	if plaintext, err := rotated.Open(envelope, []byte("user_token:1")); err != nil || string(plaintext) != "gho_secret" {
+		t.Fatalf("Open of an old envelope = %q, %v", plaintext, err)
+	}
+	// New values use the new key.
+	fresh, err := rotated.Seal([]byte("gho_other"), []byte("user_token:2"))
+	if err != nil || fresh.KeyID != "k2" {
+		t.Fatalf("Seal used key %q, %v, want k2", fresh.KeyID, err)
+	}
+
+	rewrapped, err := rotated.Rewrap(envelope)
+	if err != nil {
+		t.Fatalf("Rewrap: %v", err)
+	}
+	if rewrapped.KeyID != "k2" || !bytes.Equal(rewrapped.Ciphertext, envelope.Ciphertext) {
+		t.Errorf("Rewrap = %+v, want the same ciphertext under k2", rewrapped)
+	}
+	// Once the old key is dropped, only rewrapped values open.
+	current := testKeyring(t, "k2", "k2")
+	if _, err := current.Open(envelope, []byte("user_token:1")); err == nil {
+		t.Error("opened an envelope wrapped by a dropped key")
+	}
+	if plaintext, err := current.Open(rewrapped, []byte("user_token:1")); err != nil || string(plaintext) != "gho_secret" {
+		t.Errorf("Open of a rewrapped envelope = %q, %v", plaintext, err)
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	keyring := testKeyring(t, "k2", "k1", "k2")
+	aad := []byte("user_token:1")
+	seal := func() *Envelope {
+		envelope, err := keyring.Seal([]byte("gho_secret"), aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return envelope
+	}
+
+	tests := map[string]func(e *Envelope) []byte{
+		"ciphertext": func(e *Envelope) []byte {
+			e.Ciphertext[len(e.Ciphertext)-1] ^= 1
+			return aad
+		},
+		"wrapped key": func(e *Envelope) []byte {
+			e.WrappedKey[len(e.WrappedKey)-1] ^= 1
+			return aad
+		},
+		"key ID": func(e *Envelope) []byte {
+			e.KeyID = "k1"
+			return aad
+		},
+		"unknown key ID": func(e *Envelope) []byte {
+			e.KeyID = "k3"
+			return aad
+		},
+		"other user": func(e *Envelope) []byte {
+			return []byte("user_token:2")
+		},
+		"downgraded version": func(e *Envelope) []byte {
+			e.Version = envelopeV1
+			return aad
+		},
+		"truncated ciphertext": func(e *Envelope) []byte {
+			e.Ciphertext = e.Ciphertext[:4]
+			return aad
+		},
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			envelope := seal()
+			openWith := tamper(envelope)
+			if plaintext, err := keyring.Open(envelope, openWith); err == nil {
+				t.Errorf("tampered envelope opened to %q", plaintext)
+			}
+		})
+	}
+}
+
+func TestUnboundEnvelope(t *testing.T) {
+	keyring := testKeyring(t, "k1", "k1")
+	// An envelope written before values were bound to associated data.
+	dataKey := testKey(9)
+	ciphertext, err := gcmSeal(dataKey, []byte("gho_secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := gcmSeal(keyring.keys["k1"], dataKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := (&Envelope{Version: envelopeV1, KeyID: "k1", WrappedKey: wrapped, Ciphertext: ciphertext}).String()
+
+	envelope, err := ParseEnvelope(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Bound() {
+		t.Error("v1 envelope reported as bound")
+	}
+	if plaintext, err := keyring.Open(envelope, []byte("user_token:1")); err != nil || string(plaintext) != "gho_secret" {
+		t.Errorf("Open of a v1 envelope = %q, %v", plaintext, err)
+	}
+}
+
+func TestParseEnvelopeRejectsMalformed(t *testing.T) {
+	for _, value := range []string{"", "gho_plain", "v1.k1.AA", "v3.k1.AA.AA", "v2.k1.!!.AA", "v2.k1.AA.!!"} {
+		if _, err := ParseEnvelope(value); err == nil {
+			t.Errorf("ParseEnvelope(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const masterKeySize = 32
+
+// Keyring holds the master keys used to wrap per-record data keys. Every key
+// can decrypt; only the active key is used to encrypt.
+type Keyring struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+func NewKeyring(keys map[string][]byte, activeID string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring has no keys")
+	}
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ".:, \t") {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("master key %q must be %d bytes, got %d", id, masterKeySize, len(key))
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the keyring", activeID)
+	}
+
+	copied := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		copied[id] = append([]byte(nil), key...)
+	}
+	return &Keyring{keys: copied, activeID: activeID}, nil
+}
+
+// LoadKeyring reads master keys from TOKEN_ENCRYPTION_KEYS_FILE (one
+// "id:base64key" per line) or TOKEN_ENCRYPTION_KEYS (comma separated). The
+// active key is TOKEN_ENCRYPTION_ACTIVE_KEY, or the last key listed. It
+// returns nil when no keys are configured.
+func LoadKeyring() (*Keyring, error) {
+	var entries []string
+	if path := os.Getenv("TOKEN_ENCRYPTION_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		entries = strings.Split(string(data), "\n")
+	} else if value := os.Getenv("TOKEN_ENCRYPTION_KEYS"); value != "" {
+		entries = strings.Split(value, ",")
+	} else {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte)
+	activeID := ""
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key entry, expected id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode master key %q: %w", id, err)
+		}
+		keys[strings.TrimSpace(id)] = key
+		activeID = strings.TrimSpace(id)
+	}
+
+	if configured := os.Getenv("TOKEN_ENCRYPTION_ACTIVE_KEY"); configured != "" {
+		activeID = configured
+	}
+
+	return NewKeyring(keys, activeID)
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", id)
+	}
+	return key, nil
+}
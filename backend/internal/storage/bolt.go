@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const defaultDatabasePath = "data/repo-manager.db"
+
+// Open opens (creating if necessary) the embedded bbolt database used for
+// everything the backend has to keep across restarts.
+func Open(path string) (*bolt.DB, error) {
+	if path == "" {
+		path = DatabasePath()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+// OpenReadOnly opens an existing database for reading, for tools that run
+// alongside the backend. bbolt locks the file, so it waits for a running
+// backend to release it and fails after a timeout.
+func OpenReadOnly(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// DatabasePath returns the configured database file, falling back to a file
+// under ./data so local development works without any configuration.
+func DatabasePath() string {
+	if path := os.Getenv("DATABASE_PATH"); path != "" {
+		return path
+	}
+	return defaultDatabasePath
+}
+
+// EnsureBuckets creates the given top-level buckets if they do not exist yet.
+func EnsureBuckets(db *bolt.DB, buckets ...[]byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+}
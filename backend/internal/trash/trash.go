@@ -0,0 +1,517 @@
+package trash
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github-repo-manager/internal/audit"
+	"github-repo-manager/internal/backup"
+	"github-repo-manager/internal/policy"
+	"github-repo-manager/internal/repocache"
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultRetention = 7 * 24 * time.Hour
+
+	// maxPurgeAttempts is how often deleting an expired entry's repository
+	// is tried, once per purge pass, before the entry is marked failed.
+	maxPurgeAttempts = 5
+
+	// Topic marks repositories waiting in the trash.
+	Topic = "pending-deletion"
+)
+
+// Entry statuses.
+const (
+	StatusTrashed  = "trashed"
+	StatusRestored = "restored"
+	StatusDeleted  = "deleted"
+	StatusFailed   = "failed"
+	// StatusSkipped means the repository was changed while in the trash,
+	// e.g. unarchived, or became protected, so it wasn't deleted.
+	StatusSkipped = "skipped"
+)
+
+var (
+	ErrEntryNotFound  = errors.New("trash entry not found")
+	ErrNotTrashed     = errors.New("repository is no longer in the trash")
+	ErrAlreadyTrashed = errors.New("repository is already in the trash")
+)
+
+var trashBucket = []byte("trash")
+
+// Entry records a repository moved to the trash and the settings it had
+// before, so it can be restored until it expires.
+type Entry struct {
+	ID           string   `json:"id"`
+	UserID       int      `json:"user_id"`
+	RepositoryID int      `json:"repository_id"`
+	FullName     string   `json:"full_name"`
+	Private      bool     `json:"private"`
+	Archived     bool     `json:"archived"`
+	Topics       []string `json:"topics"`
+	Status       string   `json:"status"`
+	Error        string   `json:"error,omitempty"`
+	// PurgeAttempts counts failed attempts to delete the repository after
+	// it expired.
+	PurgeAttempts int        `json:"purge_attempts,omitempty"`
+	TrashedAt     time.Time  `json:"trashed_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// Service moves repositories to the trash, restores them, and deletes them
+// for real once they expire.
+type Service struct {
+	db        *bolt.DB
+	retention time.Duration
+	cache     *repocache.Cache
+	backups   *backup.Service
+	policy    *policy.Policy
+	audit     *audit.Log
+
+	// mu serializes state changes so an entry isn't restored and purged
+	// at the same time.
+	mu sync.Mutex
+}
+
+// New creates the trash. backups, protection and auditLog may be nil.
+func New(db *bolt.DB, retention time.Duration, cache *repocache.Cache, backups *backup.Service, protection *policy.Policy, auditLog *audit.Log) (*Service, error) {
+	if err := storage.EnsureBuckets(db, trashBucket); err != nil {
+		return nil, fmt.Errorf("failed to initialize trash: %w", err)
+	}
+	if retention <= 0 {
+		retention = defaultRetention
+	}
+	return &Service{db: db, retention: retention, cache: cache, backups: backups, policy: protection, audit: auditLog}, nil
+}
+
+// Enabled reports whether DELETE_MODE asks for deletes to go to the trash.
+func Enabled() bool {
+	return os.Getenv("DELETE_MODE") == "trash"
+}
+
+// Trash makes the repository private and archived, tags it with Topic and
+// records it for deletion after the retention period. Trashing a repository
+// that already has an entry, e.g. when an interrupted delete is retried,
+// finishes moving it with that entry, which holds the settings to restore.
+func (s *Service) Trash(ctx context.Context, client *repository.GitHubClient, userID int, owner, name string) (*Entry, *repository.Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repo, err := client.GetRepository(ctx, owner, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	topics, err := client.GetTopics(ctx, owner, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, err := s.activeEntry(repo.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case entry != nil && entry.UserID != userID:
+		return nil, nil, fmt.Errorf("%w of another user", ErrAlreadyTrashed)
+	case entry == nil && contains(topics, Topic):
+		// Its current settings are the trash's, not the ones to restore.
+		return nil, nil, fmt.Errorf("%w: it is tagged %s but has no trash entry", ErrAlreadyTrashed, Topic)
+	case entry == nil:
+		entry, err = s.newEntry(userID, repo, topics)
+	default:
+		err = s.reuse(entry)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, err = s.moveToTrash(ctx, client, repo, entry)
+	if err != nil {
+		// Never purge a repository that didn't make it into the trash.
+		s.finish(entry, StatusFailed, err)
+		return entry, nil, err
+	}
+	return entry, repo, nil
+}
+
+// newEntry records the settings repo has before it is trashed. They are saved
+// before anything changes so a failure halfway can still be restored.
+func (s *Service) newEntry(userID int, repo *repository.Repository, topics []string) (*Entry, error) {
+	id, err := newEntryID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entry := &Entry{
+		ID:           id,
+		UserID:       userID,
+		RepositoryID: repo.ID,
+		FullName:     repo.FullName,
+		Private:      repo.Private,
+		Archived:     repo.Archived,
+		Topics:       without(topics, Topic),
+		Status:       StatusTrashed,
+		TrashedAt:    now,
+		ExpiresAt:    now.Add(s.retention),
+	}
+	if err := s.save(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// reuse puts an entry whose move to the trash failed back in the trash, for
+// the full retention period. Trashed entries keep their expiry.
+func (s *Service) reuse(entry *Entry) error {
+	if entry.Status == StatusTrashed {
+		return nil
+	}
+	now := time.Now()
+	entry.Status = StatusTrashed
+	entry.Error = ""
+	entry.PurgeAttempts = 0
+	entry.FinishedAt = nil
+	entry.TrashedAt = now
+	entry.ExpiresAt = now.Add(s.retention)
+	return s.save(entry)
+}
+
+// activeEntry returns the entry of a repository that is in the trash, or
+// failed to get there or out of it, if there is one.
+func (s *Service) activeEntry(repositoryID int) (*Entry, error) {
+	entries, err := s.list(func(entry *Entry) bool {
+		return entry.RepositoryID == repositoryID && (entry.Status == StatusTrashed || entry.Status == StatusFailed)
+	})
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+func (s *Service) moveToTrash(ctx context.Context, client *repository.GitHubClient, repo *repository.Repository, entry *Entry) (*repository.Repository, error) {
+	owner, name := repo.Owner.Login, repo.Name
+
+	// Archived repositories are read-only, topics included.
+	if repo.Archived {
+		if _, err := client.UpdateRepository(ctx, owner, name, repository.RepositoryUpdate{Archived: boolPtr(false)}); err != nil {
+			return nil, err
+		}
+	}
+	if err := client.ReplaceTopics(ctx, owner, name, append(append([]string(nil), entry.Topics...), Topic)); err != nil {
+		return nil, err
+	}
+	return client.UpdateRepository(ctx, owner, name, repository.RepositoryUpdate{Private: boolPtr(true), Archived: boolPtr(true)})
+}
+
+// Restore puts back the settings the repository had before it was trashed,
+// on behalf of actor.
+func (s *Service) Restore(ctx context.Context, client *repository.GitHubClient, actor audit.Actor, entryID string) (*Entry, *repository.Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.Get(entryID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry.Status != StatusTrashed && entry.Status != StatusFailed && entry.Status != StatusSkipped {
+		return entry, nil, ErrNotTrashed
+	}
+
+	record := &audit.Entry{
+		Actor:        actor,
+		Action:       audit.ActionRestore,
+		RepositoryID: entry.RepositoryID,
+		FullName:     entry.FullName,
+	}
+	repo, err := s.restore(ctx, client, entry, record)
+	s.audit.Record(record, err)
+	if err != nil {
+		return entry, nil, err
+	}
+
+	if err := s.cache.Put(entry.UserID, *repo); err != nil {
+		log.Printf("Failed to update repository cache for user %d: %v", entry.UserID, err)
+	}
+	s.finish(entry, StatusRestored, nil)
+	return entry, repo, nil
+}
+
+func (s *Service) restore(ctx context.Context, client *repository.GitHubClient, entry *Entry, record *audit.Entry) (*repository.Repository, error) {
+	// Looked up by ID in case the repository was renamed meanwhile.
+	repo, err := client.GetRepositoryByID(ctx, entry.RepositoryID)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := client.GetTopics(ctx, repo.Owner.Login, repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	repo.Topics = topics
+	record.FullName = repo.FullName
+	record.Before = audit.RepositoryState(repo)
+	owner, name := repo.Owner.Login, repo.Name
+
+	if repo.Archived {
+		if _, err := client.UpdateRepository(ctx, owner, name, repository.RepositoryUpdate{Archived: boolPtr(false)}); err != nil {
+			return nil, err
+		}
+	}
+	if err := client.ReplaceTopics(ctx, owner, name, entry.Topics); err != nil {
+		return nil, err
+	}
+	repo, err = client.UpdateRepository(ctx, owner, name, repository.RepositoryUpdate{Private: &entry.Private, Archived: &entry.Archived})
+	if err != nil {
+		return nil, err
+	}
+	repo.Topics = entry.Topics
+	record.After = audit.RepositoryState(repo)
+	record.GitHubStatus = http.StatusOK
+	return repo, nil
+}
+
+// Get returns a trash entry.
+func (s *Service) Get(id string) (*Entry, error) {
+	var entry *Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(trashBucket).Get([]byte(id))
+		if data == nil {
+			return ErrEntryNotFound
+		}
+		entry = &Entry{}
+		return json.Unmarshal(data, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// List returns the entries of a user, newest first.
+func (s *Service) List(userID int) ([]*Entry, error) {
+	return s.list(func(entry *Entry) bool { return entry.UserID == userID })
+}
+
+// StartPurger deletes expired entries' repositories on each interval until
+// ctx is cancelled. client returns nil for users without a usable token.
+func (s *Service) StartPurger(ctx context.Context, interval time.Duration, client func(userID int) *repository.GitHubClient) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		expired, err := s.list(func(entry *Entry) bool {
+			return entry.Status == StatusTrashed && now.After(entry.ExpiresAt)
+		})
+		if err != nil {
+			log.Printf("Trash: failed to list expired entries: %v", err)
+			continue
+		}
+		for _, entry := range expired {
+			githubClient := client(entry.UserID)
+			if githubClient == nil {
+				continue
+			}
+			if err := s.purge(ctx, githubClient, entry.ID); err != nil {
+				log.Printf("Trash: failed to delete %s: %v", entry.FullName, err)
+			}
+		}
+	}
+}
+
+// purge deletes the repository of an expired entry for real, backing it up
+// first if backups are enabled. A repository changed while in the trash is
+// skipped; one that couldn't be backed up or deleted is tried again on the
+// next pass, up to maxPurgeAttempts times.
+func (s *Service) purge(ctx context.Context, client *repository.GitHubClient, entryID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Re-read under the lock: it may have been restored meanwhile.
+	entry, err := s.Get(entryID)
+	if err != nil || entry.Status != StatusTrashed {
+		return err
+	}
+
+	repo, err := client.GetRepositoryByID(ctx, entry.RepositoryID)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Already deleted outside this app.
+		s.finish(entry, StatusDeleted, nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	owner, name := repo.Owner.Login, repo.Name
+	if repo.Topics, err = client.GetTopics(ctx, owner, name); err != nil {
+		return err
+	}
+
+	// Purges happen on the backend's own schedule, not a request.
+	record := &audit.Entry{
+		Actor:        audit.Actor{UserID: entry.UserID, Username: audit.SystemUsername},
+		Action:       audit.ActionPurge,
+		RepositoryID: repo.ID,
+		FullName:     repo.FullName,
+		Before:       audit.RepositoryState(repo),
+	}
+
+	if err := s.checkPurgeable(repo); err != nil {
+		s.audit.Record(record, err)
+		s.finish(entry, StatusSkipped, err)
+		log.Printf("Trash: not deleting %s: %v", repo.FullName, err)
+		return nil
+	}
+
+	if s.backups != nil {
+		archive, err := s.backups.Backup(ctx, client, owner, name)
+		if err != nil {
+			err = fmt.Errorf("backup failed, repository not deleted: %w", err)
+			s.audit.Record(record, err)
+			s.attemptFailed(entry, err)
+			return err
+		}
+		log.Printf("Backed up %s to %s before deleting it", repo.FullName, archive.Path)
+	}
+
+	err = client.DeleteRepository(ctx, owner, name)
+	if err == nil {
+		record.GitHubStatus = http.StatusNoContent
+	}
+	s.audit.Record(record, err)
+	if err != nil {
+		s.attemptFailed(entry, err)
+		return err
+	}
+	if err := s.cache.Remove(entry.UserID, repo.FullName); err != nil {
+		log.Printf("Failed to update repository cache for user %d: %v", entry.UserID, err)
+	}
+	s.finish(entry, StatusDeleted, nil)
+	log.Printf("Trash: deleted %s for user %d", repo.FullName, entry.UserID)
+	return nil
+}
+
+// checkPurgeable checks that repo is still the way it was left in the trash
+// and that the protection policy allows deleting it.
+func (s *Service) checkPurgeable(repo *repository.Repository) error {
+	if !repo.Archived {
+		return fmt.Errorf("%w: it was unarchived", ErrNotTrashed)
+	}
+	if !contains(repo.Topics, Topic) {
+		return fmt.Errorf("%w: the %s topic was removed", ErrNotTrashed, Topic)
+	}
+	return s.policy.Check(repo)
+}
+
+// attemptFailed records a failed purge. The entry stays in the trash to be
+// tried again, until it has failed maxPurgeAttempts times.
+func (s *Service) attemptFailed(entry *Entry, err error) {
+	entry.PurgeAttempts++
+	if entry.PurgeAttempts >= maxPurgeAttempts {
+		s.finish(entry, StatusFailed, err)
+		log.Printf("Trash: giving up on deleting %s after %d attempts", entry.FullName, entry.PurgeAttempts)
+		return
+	}
+	entry.Error = err.Error()
+	if err := s.save(entry); err != nil {
+		log.Printf("Trash: failed to save entry %s: %v", entry.ID, err)
+	}
+}
+
+func (s *Service) finish(entry *Entry, status string, err error) {
+	now := time.Now()
+	entry.Status = status
+	entry.Error = ""
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	entry.FinishedAt = &now
+	if err := s.save(entry); err != nil {
+		log.Printf("Trash: failed to save entry %s: %v", entry.ID, err)
+	}
+}
+
+func (s *Service) save(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode trash entry: %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(trashBucket).Put([]byte(entry.ID), data)
+	})
+}
+
+func (s *Service) list(keep func(entry *Entry) bool) ([]*Entry, error) {
+	entries := make([]*Entry, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(trashBucket).ForEach(func(_, data []byte) error {
+			entry := &Entry{}
+			if err := json.Unmarshal(data, entry); err != nil {
+				return err
+			}
+			if keep(entry) {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].TrashedAt.After(entries[j].TrashedAt)
+	})
+	return entries, nil
+}
+
+func newEntryID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate trash entry ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func without(values []string, value string) []string {
+	kept := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,283 @@
+package trash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github-repo-manager/internal/audit"
+	"github-repo-manager/internal/backup"
+	"github-repo-manager/internal/policy"
+	"github-repo-manager/internal/repocache"
+	"github-repo-manager/internal/repository"
+	"github-repo-manager/internal/repository/githubtest"
+	"github-repo-manager/internal/storage"
+)
+
+// fakeGitHub serves one repository, octo/demo, in the state it is in,
+// applies changes to it and counts them.
+type fakeGitHub struct {
+	private  bool
+	archived bool
+	topics   []string
+	cloneURL string
+	changes  int
+	deletes  int
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	repo := func() {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": 1, "name": "demo", "full_name": "octo/demo", "owner": map[string]string{"login": "octo"},
+			"private": f.private, "archived": f.archived, "topics": f.topics, "clone_url": f.cloneURL,
+		})
+	}
+	switch {
+	case r.Method == http.MethodGet && (r.URL.Path == "/repositories/1" || r.URL.Path == "/repos/octo/demo"):
+		repo()
+	case r.Method == http.MethodPatch && r.URL.Path == "/repos/octo/demo":
+		var update repository.RepositoryUpdate
+		json.NewDecoder(r.Body).Decode(&update)
+		if f.archived && (update.Archived == nil || *update.Archived) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if update.Private != nil {
+			f.private = *update.Private
+		}
+		if update.Archived != nil {
+			f.archived = *update.Archived
+		}
+		f.changes++
+		repo()
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/octo/demo/topics":
+		json.NewEncoder(w).Encode(map[string]interface{}{"names": f.topics})
+	case r.Method == http.MethodPut && r.URL.Path == "/repos/octo/demo/topics":
+		if f.archived {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body struct {
+			Names []string `json:"names"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.topics = body.Names
+		f.changes++
+		json.NewEncoder(w).Encode(body)
+	case r.Method == http.MethodDelete && r.URL.Path == "/repos/octo/demo":
+		f.deletes++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestTrash returns a trash holding an expired entry of octo/demo.
+func newTestTrash(t *testing.T, fake *fakeGitHub, backups *backup.Service, protection *policy.Policy) (*Service, *repository.GitHubClient, *Entry) {
+	t.Helper()
+	service, client := newTestService(t, fake, backups, protection)
+
+	trashedAt := time.Now().Add(-2 * time.Hour)
+	entry := &Entry{
+		ID:           "entry",
+		UserID:       1,
+		RepositoryID: 1,
+		FullName:     "octo/demo",
+		Topics:       []string{"cli"},
+		Status:       StatusTrashed,
+		TrashedAt:    trashedAt,
+		ExpiresAt:    trashedAt.Add(time.Hour),
+	}
+	if err := service.save(entry); err != nil {
+		t.Fatal(err)
+	}
+	return service, client, entry
+}
+
+func newTestService(t *testing.T, fake *fakeGitHub, backups *backup.Service, protection *policy.Policy) (*Service, *repository.GitHubClient) {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := githubtest.NewClient(t, server.URL)
+
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cache, err := repocache.New(db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := New(db, time.Hour, cache, backups, protection, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service, client
+}
+
+func TestPurge(t *testing.T) {
+	fake := &fakeGitHub{archived: true, topics: []string{"cli", Topic}}
+	service, client, entry := newTestTrash(t, fake, nil, nil)
+
+	if err := service.purge(context.Background(), client, entry.ID); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if fake.deletes != 1 {
+		t.Errorf("repository deleted %d times, want once", fake.deletes)
+	}
+	if entry, _ = service.Get(entry.ID); entry.Status != StatusDeleted {
+		t.Errorf("status = %q, want %q", entry.Status, StatusDeleted)
+	}
+}
+
+func TestPurgeSkipsChangedRepositories(t *testing.T) {
+	tests := []struct {
+		name       string
+		fake       *fakeGitHub
+		protection *policy.Policy
+	}{
+		{name: "unarchived", fake: &fakeGitHub{archived: false, topics: []string{"cli", Topic}}},
+		{name: "topic removed", fake: &fakeGitHub{archived: true, topics: []string{"cli"}}},
+		{
+			name:       "protected",
+			fake:       &fakeGitHub{archived: true, topics: []string{"cli", Topic}},
+			protection: &policy.Policy{Patterns: []string{"octo/*"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, client, entry := newTestTrash(t, tt.fake, nil, tt.protection)
+
+			if err := service.purge(context.Background(), client, entry.ID); err != nil {
+				t.Fatalf("purge: %v", err)
+			}
+			if tt.fake.deletes != 0 {
+				t.Errorf("repository deleted %d times", tt.fake.deletes)
+			}
+			entry, _ = service.Get(entry.ID)
+			if entry.Status != StatusSkipped || entry.Error == "" {
+				t.Errorf("entry = %+v, want it skipped with a reason", entry)
+			}
+			// A skipped entry is left out of later passes.
+			if err := service.purge(context.Background(), client, entry.ID); err != nil || tt.fake.deletes != 0 {
+				t.Errorf("second purge: %v, %d deletes", err, tt.fake.deletes)
+			}
+		})
+	}
+}
+
+func TestPurgeRetriesFailedBackups(t *testing.T) {
+	fake := &fakeGitHub{
+		archived: true,
+		topics:   []string{"cli", Topic},
+		cloneURL: "file://" + filepath.Join(t.TempDir(), "missing.git"),
+	}
+	service, client, entry := newTestTrash(t, fake, backup.New(t.TempDir()), nil)
+
+	for attempt := 1; attempt <= maxPurgeAttempts; attempt++ {
+		if err := service.purge(context.Background(), client, entry.ID); err == nil {
+			t.Fatalf("attempt %d: purge without a backup succeeded", attempt)
+		}
+		entry, _ = service.Get(entry.ID)
+		if entry.PurgeAttempts != attempt || entry.Error == "" {
+			t.Errorf("attempt %d: entry = %+v", attempt, entry)
+		}
+		want := StatusTrashed
+		if attempt == maxPurgeAttempts {
+			want = StatusFailed
+		}
+		if entry.Status != want {
+			t.Errorf("attempt %d: status = %q, want %q", attempt, entry.Status, want)
+		}
+	}
+	if fake.deletes != 0 {
+		t.Errorf("repository deleted %d times without a backup", fake.deletes)
+	}
+}
+
+func TestTrashTwiceKeepsPreviousSettings(t *testing.T) {
+	fake := &fakeGitHub{topics: []string{"cli"}}
+	service, client := newTestService(t, fake, nil, nil)
+	ctx := context.Background()
+
+	first, _, err := service.Trash(ctx, client, 1, "octo", "demo")
+	if err != nil {
+		t.Fatalf("Trash: %v", err)
+	}
+	if !fake.private || !fake.archived || len(fake.topics) != 2 {
+		t.Fatalf("repository not moved to the trash: %+v", fake)
+	}
+
+	// A retried or repeated delete finds the repository already trashed.
+	second, _, err := service.Trash(ctx, client, 1, "octo", "demo")
+	if err != nil {
+		t.Fatalf("second Trash: %v", err)
+	}
+	if second.ID != first.ID || second.Private || second.Archived || len(second.Topics) != 1 || !second.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Errorf("second Trash recorded %+v, want the first entry %+v", second, first)
+	}
+	if entries, _ := service.List(1); len(entries) != 1 {
+		t.Errorf("%d entries, want 1", len(entries))
+	}
+
+	if _, _, err := service.Restore(ctx, client, audit.Actor{UserID: 1, Username: "octo"}, first.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if fake.private || fake.archived || len(fake.topics) != 1 || fake.topics[0] != "cli" {
+		t.Errorf("restored repository = %+v, want public, unarchived and tagged cli", fake)
+	}
+}
+
+func TestTrashReusesFailedEntry(t *testing.T) {
+	fake := &fakeGitHub{topics: []string{"cli"}}
+	service, client := newTestService(t, fake, nil, nil)
+	ctx := context.Background()
+
+	first, _, err := service.Trash(ctx, client, 1, "octo", "demo")
+	if err != nil {
+		t.Fatalf("Trash: %v", err)
+	}
+	service.finish(first, StatusFailed, errors.New("interrupted"))
+
+	second, _, err := service.Trash(ctx, client, 1, "octo", "demo")
+	if err != nil {
+		t.Fatalf("second Trash: %v", err)
+	}
+	if second.ID != first.ID || second.Status != StatusTrashed || second.Error != "" || second.FinishedAt != nil || second.Private {
+		t.Errorf("second Trash = %+v, want the first entry trashed again", second)
+	}
+}
+
+func TestTrashRefusesTaggedRepositories(t *testing.T) {
+	t.Run("without an entry", func(t *testing.T) {
+		fake := &fakeGitHub{private: true, archived: true, topics: []string{"cli", Topic}}
+		service, client := newTestService(t, fake, nil, nil)
+
+		entry, _, err := service.Trash(context.Background(), client, 1, "octo", "demo")
+		if !errors.Is(err, ErrAlreadyTrashed) || entry != nil {
+			t.Errorf("Trash = %+v, %v, want %v", entry, err, ErrAlreadyTrashed)
+		}
+		if fake.changes != 0 {
+			t.Errorf("repository changed %d times", fake.changes)
+		}
+	})
+
+	t.Run("of another user", func(t *testing.T) {
+		fake := &fakeGitHub{private: true, archived: true, topics: []string{"cli", Topic}}
+		service, client, _ := newTestTrash(t, fake, nil, nil)
+
+		if _, _, err := service.Trash(context.Background(), client, 2, "octo", "demo"); !errors.Is(err, ErrAlreadyTrashed) {
+			t.Errorf("err = %v, want %v", err, ErrAlreadyTrashed)
+		}
+		if fake.changes != 0 {
+			t.Errorf("repository changed %d times", fake.changes)
+		}
+	})
+}
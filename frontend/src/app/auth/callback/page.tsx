@@ -14,8 +14,10 @@ export default function AuthCallback() {
       // Store the token in localStorage
       localStorage.setItem('auth_token', token)
       
-      // Redirect to dashboard
-      router.push('/dashboard')
+      // Return to where the login started (only local paths are allowed)
+      const returnTo = searchParams.get('return_to') || ''
+      const isLocalPath = returnTo.startsWith('/') && !returnTo.startsWith('//') && !returnTo.includes('\\')
+      router.push(isLocalPath ? returnTo : '/dashboard')
     } else {
       // If no token, redirect to home with error
       router.push('/?error=auth_failed')
@@ -153,7 +153,7 @@ export default function Dashboard() {
     return Array.isArray(repositories) ? repositories.filter(repo => selectedRepos.has(repo.id)) : []
   }
 
-  const handleBulkOperation = async (action: 'makePrivate' | 'makePublic' | 'archive' | 'unarchive' | 'delete') => {
+  const handleBulkOperation = async (action: 'makePrivate' | 'makePublic' | 'archive' | 'unarchive' | 'delete', confirmationToken?: string) => {
     setBulkLoading(true)
     try {
       const selectedRepositories = getSelectedRepositories()
@@ -165,7 +165,8 @@ export default function Dashboard() {
       let result: BulkOperationResult
 
       if (action === 'delete') {
-        result = await repositoryApi.bulkDeleteRepositories(repoData)
+        if (!confirmationToken) return
+        result = await repositoryApi.bulkDeleteRepositories(repoData, confirmationToken)
       } else {
         const updates: { private?: boolean; archived?: boolean } = {}
         
@@ -1,12 +1,15 @@
 'use client'
 
-import { useState } from 'react'
-import type { Repository } from '@/types'
+import { useState, useEffect } from 'react'
+import { repositoryApi } from '@/lib/api'
+import type { BulkDryRunResult, Repository } from '@/types'
+import DeletePlanPreview from '@/components/DeletePlanPreview'
 
 interface BulkOperationModalProps {
   isOpen: boolean
   onClose: () => void
-  onConfirm: (action: 'makePrivate' | 'makePublic' | 'archive' | 'unarchive' | 'delete') => void
+  // confirmationToken is set for deletes, from the preview the user saw
+  onConfirm: (action: 'makePrivate' | 'makePublic' | 'archive' | 'unarchive' | 'delete', confirmationToken?: string) => void
   repositories: Repository[]
   loading: boolean
 }
@@ -20,14 +23,50 @@ export default function BulkOperationModal({
 }: BulkOperationModalProps) {
   const [confirmText, setConfirmText] = useState('')
   const [selectedAction, setSelectedAction] = useState<'makePrivate' | 'makePublic' | 'archive' | 'unarchive' | 'delete' | null>(null)
+  const [deletePlan, setDeletePlan] = useState<BulkDryRunResult | null>(null)
+  const [deletePlanLoading, setDeletePlanLoading] = useState(false)
+  const [deletePlanError, setDeletePlanError] = useState<string | null>(null)
+
+  // The parent passes a new array on every render; only a change of the
+  // selection itself needs a new preview
+  const repositoryKey = repositories.map(repo => repo.full_name).join('\n')
+
+  // Preview the delete as soon as it is selected, so the user confirms
+  // having seen what would be lost
+  useEffect(() => {
+    setDeletePlan(null)
+    setDeletePlanError(null)
+    if (!isOpen || selectedAction !== 'delete') return
+
+    let cancelled = false
+    setDeletePlanLoading(true)
+    repositoryApi
+      .planBulkDelete(repositories.map(repo => ({ owner: repo.owner.login, name: repo.name })))
+      .then(plan => {
+        if (!cancelled) setDeletePlan(plan)
+      })
+      .catch(error => {
+        console.error('Failed to preview bulk deletion:', error)
+        if (!cancelled) setDeletePlanError('削除内容の確認に失敗しました')
+      })
+      .finally(() => {
+        if (!cancelled) setDeletePlanLoading(false)
+      })
+    return () => {
+      cancelled = true
+    }
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [isOpen, selectedAction, repositoryKey])
 
   if (!isOpen) return null
 
   const handleConfirm = () => {
     if (selectedAction === 'delete') {
-      if (confirmText !== 'DELETE') {
+      if (confirmText !== 'DELETE' || !deletePlan?.confirmation_token) {
         return
       }
+      onConfirm(selectedAction, deletePlan.confirmation_token)
+      return
     }
     if (selectedAction) {
       onConfirm(selectedAction)
@@ -52,7 +91,7 @@ export default function BulkOperationModal({
   }
 
   const isDeleteAction = selectedAction === 'delete'
-  const canConfirm = selectedAction && (!isDeleteAction || confirmText === 'DELETE')
+  const canConfirm = selectedAction && (!isDeleteAction || (confirmText === 'DELETE' && !!deletePlan?.confirmation_token))
 
   return (
     <div className="fixed inset-0 bg-black bg-opacity-50 flex items-center justify-center z-50">
@@ -141,6 +180,11 @@ export default function BulkOperationModal({
           </p>
         </div>
 
+        {/* Delete Preview */}
+        {isDeleteAction && (
+          <DeletePlanPreview plan={deletePlan} loading={deletePlanLoading} error={deletePlanError} />
+        )}
+
         {/* Delete Confirmation */}
         {isDeleteAction && (
           <div className="mb-4">
@@ -0,0 +1,57 @@
+'use client'
+
+import type { BulkDryRunResult } from '@/types'
+
+interface DeletePlanPreviewProps {
+  plan: BulkDryRunResult | null
+  loading: boolean
+  error: string | null
+}
+
+// Shows what a delete would do, as previewed by the backend, so the user
+// confirms knowing the warnings. Deleting needs the preview's confirmation
+// token, which is only issued when every repository could be checked.
+export default function DeletePlanPreview({ plan, loading, error }: DeletePlanPreviewProps) {
+  if (loading) {
+    return (
+      <div className="mb-4 p-3 rounded bg-gray-50 border text-sm text-gray-600">
+        削除内容を確認しています...
+      </div>
+    )
+  }
+
+  if (error) {
+    return (
+      <div className="mb-4 p-3 rounded bg-red-50 border border-red-200 text-sm text-red-800">
+        {error}
+      </div>
+    )
+  }
+
+  if (!plan) return null
+
+  return (
+    <div className="mb-4 max-h-48 overflow-y-auto border rounded p-2 bg-gray-50 space-y-2">
+      {plan.plan.map((item) => (
+        <div key={item.full_name} className="text-sm">
+          <div className="font-medium text-gray-900">{item.full_name}</div>
+          <div className={item.error ? 'text-red-700' : 'text-gray-600'}>
+            {item.error || item.summary}
+          </div>
+          {item.warnings.length > 0 && (
+            <ul className="list-disc list-inside text-yellow-800">
+              {item.warnings.map((warning) => (
+                <li key={warning}>{warning}</li>
+              ))}
+            </ul>
+          )}
+        </div>
+      ))}
+      {!plan.confirmation_token && (
+        <p className="text-sm text-red-800">
+          確認できなかったリポジトリがあるため削除できません。選択を見直してください。
+        </p>
+      )}
+    </div>
+  )
+}
@@ -2,7 +2,8 @@
 
 import { useState } from 'react'
 import { repositoryApi } from '@/lib/api'
-import type { Repository } from '@/types'
+import type { BulkDryRunResult, Repository } from '@/types'
+import DeletePlanPreview from '@/components/DeletePlanPreview'
 
 interface RepositoryManageModalProps {
   isOpen: boolean
@@ -20,6 +21,9 @@ export default function RepositoryManageModal({
   const [loading, setLoading] = useState(false)
   const [showDeleteConfirm, setShowDeleteConfirm] = useState(false)
   const [deleteConfirmText, setDeleteConfirmText] = useState('')
+  const [deletePlan, setDeletePlan] = useState<BulkDryRunResult | null>(null)
+  const [deletePlanLoading, setDeletePlanLoading] = useState(false)
+  const [deletePlanError, setDeletePlanError] = useState<string | null>(null)
   const [notification, setNotification] = useState<{ message: string; type: 'success' | 'error' } | null>(null)
 
   if (!isOpen || !repository) return null
@@ -88,12 +92,30 @@ export default function RepositoryManageModal({
     }
   }
 
+  const showDeletePreview = async () => {
+    if (!repository) return
+
+    setShowDeleteConfirm(true)
+    setDeletePlan(null)
+    setDeletePlanError(null)
+    setDeletePlanLoading(true)
+    try {
+      setDeletePlan(await repositoryApi.planDeleteRepository(repository.id, repository.owner.login, repository.name))
+    } catch (error) {
+      console.error('Failed to preview repository deletion:', error)
+      setDeletePlanError('削除内容の確認に失敗しました')
+    } finally {
+      setDeletePlanLoading(false)
+    }
+  }
+
   const handleDelete = async () => {
-    if (!repository || deleteConfirmText !== repository.name) return
+    const confirmationToken = deletePlan?.confirmation_token
+    if (!repository || deleteConfirmText !== repository.name || !confirmationToken) return
     
     setLoading(true)
     try {
-      await repositoryApi.deleteRepository(repository.id, repository.owner.login, repository.name)
+      await repositoryApi.deleteRepository(repository.id, repository.owner.login, repository.name, confirmationToken)
       
       setNotification({
         message: 'リポジトリを削除しました',
@@ -119,6 +141,8 @@ export default function RepositoryManageModal({
   const resetDeleteConfirm = () => {
     setShowDeleteConfirm(false)
     setDeleteConfirmText('')
+    setDeletePlan(null)
+    setDeletePlanError(null)
   }
 
   return (
@@ -224,7 +248,7 @@ export default function RepositoryManageModal({
 
             {/* Delete Button */}
             <button
-              onClick={() => setShowDeleteConfirm(true)}
+              onClick={showDeletePreview}
               disabled={loading}
               className="w-full p-3 rounded-md text-left hover:bg-red-50 border border-red-200 hover:border-red-300 transition-colors disabled:opacity-50"
             >
@@ -251,6 +275,8 @@ export default function RepositoryManageModal({
                 削除を確認するため、リポジトリ名「<strong>{repository.name}</strong>」を入力してください：
               </p>
             </div>
+
+            <DeletePlanPreview plan={deletePlan} loading={deletePlanLoading} error={deletePlanError} />
             
             <input
               type="text"
@@ -270,7 +296,7 @@ export default function RepositoryManageModal({
               </button>
               <button
                 onClick={handleDelete}
-                disabled={loading || deleteConfirmText !== repository.name}
+                disabled={loading || deleteConfirmText !== repository.name || !deletePlan?.confirmation_token}
                 className="flex-1 px-4 py-2 rounded-md text-sm font-medium text-white bg-red-600 hover:bg-red-700 disabled:opacity-50"
               >
                 {loading ? '削除中...' : '削除'}
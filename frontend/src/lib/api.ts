@@ -1,5 +1,20 @@
 import axios from 'axios'
-import type { Repository, User, RepositoryUpdateRequest, ApiResponse } from '@/types'
+import type {
+  Repository,
+  Organization,
+  User,
+  RepositoryUpdateRequest,
+  ApiResponse,
+  BulkOperationResult,
+  BulkJob,
+  BulkItemEvent,
+  BulkDryRunResult,
+  TrashEntry,
+  AuditEntry,
+  AuditFilter,
+  AuditVerification,
+  RateLimitStatus,
+} from '@/types'
 
 const API_BASE_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080'
 
@@ -17,10 +32,41 @@ api.interceptors.request.use((config) => {
   return config
 })
 
+// Exchanges the refresh token cookie for a new access token. Concurrent
+// callers share one request so the rotating refresh token is used only once.
+let refreshPromise: Promise<string> | null = null
+
+const refreshAccessToken = (): Promise<string> => {
+  if (!refreshPromise) {
+    refreshPromise = axios
+      .post<ApiResponse<{ token: string; expires_in: number }>>(`${API_BASE_URL}/api/auth/refresh`, {}, { withCredentials: true })
+      .then((response) => {
+        const token = response.data.data.token
+        localStorage.setItem('auth_token', token)
+        return token
+      })
+      .finally(() => {
+        refreshPromise = null
+      })
+  }
+  return refreshPromise
+}
+
 // Response interceptor for error handling
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config
+    if (error.response?.status === 401 && original && !original._retry) {
+      original._retry = true
+      try {
+        const token = await refreshAccessToken()
+        original.headers.Authorization = `Bearer ${token}`
+        return api(original)
+      } catch {
+        // Fall through to the login redirect below
+      }
+    }
     if (error.response?.status === 401) {
       localStorage.removeItem('auth_token')
       window.location.href = '/login'
@@ -38,6 +84,11 @@ export const authApi = {
     await api.post('/auth/logout')
     localStorage.removeItem('auth_token')
   },
+
+  logoutEverywhere: async (): Promise<void> => {
+    await api.post('/auth/logout-all')
+    localStorage.removeItem('auth_token')
+  },
   
   getCurrentUser: async (): Promise<User> => {
     const response = await api.get<ApiResponse<User>>('/auth/me')
@@ -45,15 +96,29 @@ export const authApi = {
   },
 }
 
+export const rateLimitApi = {
+  getRateLimit: async (): Promise<RateLimitStatus> => {
+    const response = await api.get<ApiResponse<RateLimitStatus>>('/rate-limit')
+    return response.data.data
+  },
+}
+
 export const repositoryApi = {
-  getRepositories: async (page: number = 1, perPage: number = 30): Promise<{ repositories: Repository[], pagination: { page: number, per_page: number, total: number } }> => {
-    const response = await api.get<ApiResponse<{ data: Repository[], pagination: { page: number, per_page: number, total: number } }>>(`/repositories?page=${page}&per_page=${perPage}`)
+  getRepositories: async (
+    page: number = 1,
+    perPage: number = 30,
+    search: { q?: string; sort?: string; direction?: 'asc' | 'desc' } = {}
+  ): Promise<{ repositories: Repository[], pagination: { page: number, per_page: number, total: number }, syncedAt?: string }> => {
+    const response = await api.get<ApiResponse<{ data: Repository[], pagination: { page: number, per_page: number, total: number }, synced_at?: string }>>('/repositories', {
+      params: { page, per_page: perPage, ...search }
+    })
     
     // Handle the nested data structure from backend
     const data = response.data.data
     return {
       repositories: Array.isArray(data?.data) ? data.data : [],
-      pagination: data?.pagination || { page: 1, per_page: 30, total: 0 }
+      pagination: data?.pagination || { page: 1, per_page: 30, total: 0 },
+      syncedAt: data?.synced_at
     }
   },
 
@@ -84,9 +149,18 @@ export const repositoryApi = {
     return response.data.data
   },
   
-  deleteRepository: async (id: number, owner: string, name: string): Promise<void> => {
+  // Previews a delete. Show the plan to the user and pass its
+  // confirmation_token to deleteRepository once they confirm.
+  planDeleteRepository: async (id: number, owner: string, name: string): Promise<BulkDryRunResult> => {
+    const response = await api.delete<ApiResponse<BulkDryRunResult>>(`/repositories/${id}`, {
+      data: { owner, name, dry_run: true }
+    })
+    return response.data.data
+  },
+
+  deleteRepository: async (id: number, owner: string, name: string, confirmationToken: string): Promise<void> => {
     await api.delete(`/repositories/${id}`, {
-      data: { owner, name }
+      data: { owner, name, confirmation_token: confirmationToken }
     })
   },
 
@@ -101,14 +175,189 @@ export const repositoryApi = {
     return response.data.data
   },
 
+  // confirmationToken comes from a planBulkDelete of the same repositories
+  // the user has seen and confirmed.
   bulkDeleteRepositories: async (
-    repositories: Array<{ owner: string; name: string }>
+    repositories: Array<{ owner: string; name: string }>,
+    confirmationToken: string
   ): Promise<BulkOperationResult> => {
     const response = await api.post<ApiResponse<BulkOperationResult>>('/repositories/bulk-delete', {
-      repositories
+      repositories,
+      confirmation_token: confirmationToken
+    })
+    return response.data.data
+  },
+
+  planBulkUpdate: async (
+    repositories: Array<{ owner: string; name: string }>,
+    updates: { private?: boolean; archived?: boolean }
+  ): Promise<BulkDryRunResult> => {
+    const response = await api.post<ApiResponse<BulkDryRunResult>>('/repositories/bulk-update', {
+      repositories,
+      updates,
+      dry_run: true
+    })
+    return response.data.data
+  },
+
+  planBulkDelete: async (
+    repositories: Array<{ owner: string; name: string }>
+  ): Promise<BulkDryRunResult> => {
+    const response = await api.post<ApiResponse<BulkDryRunResult>>('/repositories/bulk-delete', {
+      repositories,
+      dry_run: true
+    })
+    return response.data.data
+  },
+}
+
+export const orgApi = {
+  getOrganizations: async (): Promise<Organization[]> => {
+    const response = await api.get<ApiResponse<Organization[]>>('/orgs')
+    return response.data.data
+  },
+
+  getRepositories: async (
+    org: string,
+    page: number = 1,
+    perPage: number = 30,
+    search: { q?: string; sort?: string; direction?: 'asc' | 'desc' } = {}
+  ): Promise<{ repositories: Repository[], pagination: { page: number, per_page: number, total: number }, syncedAt?: string }> => {
+    const response = await api.get<ApiResponse<{ data: Repository[], pagination: { page: number, per_page: number, total: number }, synced_at?: string }>>(`/orgs/${encodeURIComponent(org)}/repositories`, {
+      params: { page, per_page: perPage, ...search }
+    })
+
+    const data = response.data.data
+    return {
+      repositories: Array.isArray(data?.data) ? data.data : [],
+      pagination: data?.pagination || { page: 1, per_page: 30, total: 0 },
+      syncedAt: data?.synced_at
+    }
+  },
+}
+
+export const trashApi = {
+  getTrash: async (): Promise<TrashEntry[]> => {
+    const response = await api.get<ApiResponse<TrashEntry[]>>('/trash')
+    return response.data.data
+  },
+
+  restore: async (id: string): Promise<{ entry: TrashEntry; repository: Repository }> => {
+    const response = await api.post<ApiResponse<{ entry: TrashEntry; repository: Repository }>>(`/trash/${id}/restore`)
+    return response.data.data
+  },
+}
+
+export const auditApi = {
+  getEntries: async (
+    filter: AuditFilter = {},
+    page: number = 1,
+    perPage: number = 50
+  ): Promise<{ entries: AuditEntry[], pagination: { page: number, per_page: number, total: number } }> => {
+    const response = await api.get<ApiResponse<{ data: AuditEntry[], pagination: { page: number, per_page: number, total: number } }>>('/audit', {
+      params: { ...filter, page, per_page: perPage }
     })
+    return {
+      entries: response.data.data.data,
+      pagination: response.data.data.pagination
+    }
+  },
+
+  // Downloads every matching entry as JSON Lines or CSV.
+  exportEntries: async (filter: AuditFilter, format: 'jsonl' | 'csv'): Promise<Blob> => {
+    const response = await api.get<Blob>('/audit', {
+      params: { ...filter, format },
+      responseType: 'blob'
+    })
+    return response.data
+  },
+
+  verify: async (): Promise<AuditVerification> => {
+    const response = await api.get<ApiResponse<AuditVerification>>('/audit/verify')
     return response.data.data
   },
 }
 
+export const jobApi = {
+  // Delete jobs need the confirmation_token of a planBulkDelete of the same
+  // repositories.
+  createJob: async (
+    operation: 'update' | 'delete',
+    repositories: Array<{ owner: string; name: string }>,
+    options: { updates?: RepositoryUpdateRequest; confirmationToken?: string } = {}
+  ): Promise<BulkJob> => {
+    const response = await api.post<ApiResponse<BulkJob>>('/jobs', {
+      operation,
+      repositories,
+      updates: options.updates,
+      confirmation_token: options.confirmationToken
+    })
+    return response.data.data
+  },
+
+  getJobs: async (): Promise<BulkJob[]> => {
+    const response = await api.get<ApiResponse<BulkJob[]>>('/jobs')
+    return response.data.data
+  },
+
+  getJob: async (id: string): Promise<BulkJob> => {
+    const response = await api.get<ApiResponse<BulkJob>>(`/jobs/${id}`)
+    return response.data.data
+  },
+
+  // Streams a job's Server-Sent Events. EventSource can't send the
+  // Authorization header, so the stream is read with fetch. Resolves when the
+  // stream ends; abort the signal to stop listening early.
+  streamJob: async (
+    id: string,
+    handlers: {
+      onItem?: (event: BulkItemEvent) => void
+      onSummary?: (result: BulkOperationResult) => void
+    },
+    signal?: AbortSignal
+  ): Promise<void> => {
+    let token = localStorage.getItem('auth_token')
+    const open = () =>
+      fetch(`${API_BASE_URL}/api/jobs/${id}/events`, {
+        headers: token ? { Authorization: `Bearer ${token}` } : {},
+        credentials: 'include',
+        signal,
+      })
+
+    let response = await open()
+    if (response.status === 401) {
+      token = await refreshAccessToken()
+      response = await open()
+    }
+    if (!response.ok || !response.body) {
+      throw new Error(`Failed to stream job ${id}: ${response.status}`)
+    }
+
+    const reader = response.body.getReader()
+    const decoder = new TextDecoder()
+    let buffer = ''
+    for (;;) {
+      const { done, value } = await reader.read()
+      if (done) break
+      buffer += decoder.decode(value, { stream: true })
+
+      let boundary = buffer.indexOf('\n\n')
+      while (boundary !== -1) {
+        const block = buffer.slice(0, boundary)
+        buffer = buffer.slice(boundary + 2)
+        boundary = buffer.indexOf('\n\n')
+
+        let event = 'message'
+        let data = ''
+        for (const line of block.split('\n')) {
+          if (line.startsWith('event:')) event = line.slice(6).trim()
+          else if (line.startsWith('data:')) data += line.slice(5)
+        }
+        if (event === 'item') handlers.onItem?.(JSON.parse(data))
+        else if (event === 'summary') handlers.onSummary?.(JSON.parse(data).data)
+      }
+    }
+  },
+}
+
 export default api
\ No newline at end of file
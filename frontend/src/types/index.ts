@@ -1,15 +1,22 @@
+// A repository as served by the API (version 1, see
+// /api/schema/repository.v1.json)
 export interface Repository {
   id: number
   name: string
   full_name: string
   description: string | null
+  homepage: string
+  visibility: 'public' | 'private' | 'internal'
   private: boolean
   archived: boolean
+  disabled: boolean
+  fork: boolean
+  is_template: boolean
   html_url: string
   clone_url: string
   created_at: string
   updated_at: string
-  pushed_at: string
+  pushed_at: string | null
   size: number
   stargazers_count: number
   watchers_count: number
@@ -17,11 +24,44 @@ export interface Repository {
   forks_count: number
   open_issues_count: number
   default_branch: string
+  topics: string[]
+  license: RepositoryLicense | null
+  permissions: RepositoryPermissions | null
+  has_issues: boolean
+  has_projects: boolean
+  has_wiki: boolean
   owner: {
     login: string
     avatar_url: string
     html_url: string
   }
+  // Only listed when the backend uses the GitHub GraphQL API
+  languages?: Record<string, number>
+  default_branch_protected?: boolean
+  last_commit_at?: string
+}
+
+export interface RepositoryLicense {
+  key: string
+  name: string
+  spdx_id: string | null
+}
+
+// The signed-in user's permissions on a repository
+export interface RepositoryPermissions {
+  admin: boolean
+  maintain: boolean
+  push: boolean
+  triage: boolean
+  pull: boolean
+}
+
+export interface Organization {
+  id: number
+  login: string
+  description: string
+  avatar_url: string
+  role: 'admin' | 'member'
 }
 
 export interface User {
@@ -61,13 +101,167 @@ export interface ConfirmationModalProps {
   type?: 'danger' | 'warning' | 'info'
 }
 
+export type BulkItemStatus = 'pending' | 'running' | 'succeeded' | 'failed' | 'skipped'
+
+export interface BulkItemResult {
+  owner: string
+  name: string
+  full_name: string
+  status: BulkItemStatus
+  error?: string
+  attempts: number
+  repository?: Repository
+}
+
+export interface BulkJobSummary {
+  total: number
+  pending: number
+  running: number
+  succeeded: number
+  failed: number
+  skipped: number
+}
+
+export interface BulkJob {
+  id: string
+  user_id: number
+  operation: 'update' | 'delete'
+  updates?: RepositoryUpdateRequest
+  status: 'pending' | 'running' | 'completed'
+  items: BulkItemResult[]
+  summary: BulkJobSummary
+  created_at: string
+  started_at?: string
+  finished_at?: string
+}
+
+export interface BulkItemEvent {
+  job_id: string
+  index: number
+  item: BulkItemResult
+  summary: BulkJobSummary
+}
+
 export interface BulkOperationResult {
+  job_id: string
+  results: BulkItemResult[]
   updated?: Repository[]
   deleted?: string[]
   errors: string[]
   total: number
   success: number
   failed: number
+  skipped: number
+}
+
+export interface BulkPlanItem {
+  owner: string
+  name: string
+  full_name: string
+  action: 'update' | 'delete' | 'none' | 'skip'
+  changes: Array<{ field: string; from: unknown; to: unknown }>
+  summary: string
+  warnings: string[]
+  error?: string
+  repository?: Repository
+}
+
+export interface BulkDryRunResult {
+  dry_run: true
+  plan: BulkPlanItem[]
+  total: number
+  changes: number
+  warnings: number
+  failed: number
+  confirmation_token?: string
+  confirmation_expires_at?: string
+}
+
+export interface TrashEntry {
+  id: string
+  user_id: number
+  repository_id: number
+  full_name: string
+  private: boolean
+  archived: boolean
+  topics: string[]
+  status: 'trashed' | 'restored' | 'deleted' | 'failed' | 'skipped'
+  error?: string
+  purge_attempts?: number
+  trashed_at: string
+  expires_at: string
+  finished_at?: string
+}
+
+export type AuditAction =
+  | 'repository.update'
+  | 'repository.delete'
+  | 'repository.trash'
+  | 'repository.restore'
+  | 'repository.purge'
+
+export interface AuditEntry {
+  sequence: number
+  time: string
+  user_id: number
+  username: string
+  source_ip?: string
+  request_id?: string
+  action: AuditAction
+  job_id?: string
+  repository_id?: number
+  full_name: string
+  before?: Record<string, unknown>
+  after?: Record<string, unknown>
+  github_status?: number
+  outcome: 'succeeded' | 'failed' | 'skipped'
+  error?: string
+  prev_hash: string
+  hash: string
+}
+
+export interface AuditCheckpoint {
+  v?: number
+  sequence: number
+  hash: string
+  chain_start?: number
+  previous?: string
+  time: string
+  key_id: string
+  signature: string
+}
+
+export interface AuditVerification {
+  ok: boolean
+  entries: number
+  unhashed?: number
+  checkpoints: number
+  last_checkpoint?: AuditCheckpoint
+  broken?: { sequence: number; reason: string }
+}
+
+export interface AuditFilter {
+  action?: AuditAction
+  repository?: string
+  outcome?: 'succeeded' | 'failed' | 'skipped'
+  request_id?: string
+  job_id?: string
+  since?: string
+  until?: string
+}
+
+export interface RateLimit {
+  resource: string
+  limit: number
+  remaining: number
+  used: number
+  reset: string
+  updated_at: string
+}
+
+export interface RateLimitStatus {
+  resources: Record<string, RateLimit>
+  paused_until?: string
 }
 
 export interface BulkDeleteModalProps {
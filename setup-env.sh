@@ -4,8 +4,8 @@
 
 echo "🔧 Setting up environment variables..."
 
-# Generate JWT Secret
-JWT_SECRET=$(openssl rand -base64 32)
+# Generate master key for encrypting stored GitHub tokens
+TOKEN_ENCRYPTION_KEYS="k1:$(openssl rand -base64 32)"
 
 echo "📝 Please enter your GitHub OAuth App credentials:"
 echo ""
@@ -19,7 +19,7 @@ read -s GITHUB_CLIENT_SECRET
 echo ""
 
 echo ""
-echo "🔐 Generated JWT Secret: $JWT_SECRET"
+echo "🔐 Generated token encryption key (k1)"
 echo ""
 
 # Create backend .env
@@ -27,7 +27,7 @@ echo "📁 Creating backend/.env..."
 cat > backend/.env << EOF
 GITHUB_CLIENT_ID=$GITHUB_CLIENT_ID
 GITHUB_CLIENT_SECRET=$GITHUB_CLIENT_SECRET
-JWT_SECRET=$JWT_SECRET
+TOKEN_ENCRYPTION_KEYS=$TOKEN_ENCRYPTION_KEYS
 PORT=8080
 FRONTEND_URL=http://localhost:3000
 EOF
@@ -44,7 +44,7 @@ echo "📁 Creating .env (for Docker)..."
 cat > .env << EOF
 GITHUB_CLIENT_ID=$GITHUB_CLIENT_ID
 GITHUB_CLIENT_SECRET=$GITHUB_CLIENT_SECRET
-JWT_SECRET=$JWT_SECRET
+TOKEN_ENCRYPTION_KEYS=$TOKEN_ENCRYPTION_KEYS
 FRONTEND_URL=http://localhost:3000
 BACKEND_URL=http://localhost:8080
 EOF